@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"xlsxtoSQL/auth"
+	"xlsxtoSQL/config"
+)
+
+// authenticator is nil when authentication is disabled.
+var authenticator auth.Authenticator
+
+// byMethod maps an HTTP method to the minimum role required for it. Methods
+// that are not listed require the admin role.
+type byMethod map[string]auth.Role
+
+func setupAuth(cfg config.AuthConfig) error {
+	if !cfg.Enabled() {
+		slog.Warn("no authenticators configured, the API is open to everyone")
+		return nil
+	}
+
+	var chain auth.Chain
+	if len(cfg.Tokens) > 0 {
+		a, err := auth.NewTokenAuthenticator(cfg.Tokens)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, a)
+	}
+	if cfg.UsersFile != "" {
+		a, err := auth.LoadUsersFile(cfg.UsersFile)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, a)
+	}
+	if cfg.JWT.JWKSFile != "" {
+		a, err := auth.NewJWTAuthenticator(cfg.JWT)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, a)
+	}
+	authenticator = chain
+	return nil
+}
+
+// withAuth authenticates the request and checks the caller's role against
+// roles before calling next. The principal is stored in the request context.
+func withAuth(roles byMethod, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		required, ok := roles[r.Method]
+		if !ok {
+			required = auth.RoleAdmin
+		}
+
+		if authenticator == nil {
+			principal := &auth.Principal{Name: "anonymous", Role: auth.RoleAdmin, Method: "none"}
+			next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+			return
+		}
+
+		principal, err := authenticator.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, auth.ErrNoCredentials) {
+				slog.Warn("rejected credentials", "method", r.Method, "path", r.URL.Path, "error", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="xlsxtoSQL", Basic realm="xlsxtoSQL"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if principal.Role < required {
+			http.Error(w, "Forbidden: requires role "+required.String(), http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}
+}
+
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"name":   principal.Name,
+		"role":   principal.Role.String(),
+		"method": principal.Method,
+	})
+}
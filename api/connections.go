@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"xlsxtoSQL/config"
+	"xlsxtoSQL/connections"
+)
+
+var registry *connections.Registry
+
+// runtimeConfig fills in the connection URL of a job right before it runs.
+func runtimeConfig(jobConfig config.Config) (config.Config, error) {
+	if jobConfig.Connection == "" {
+		return jobConfig, nil
+	}
+	connURL, err := registry.URL(jobConfig.Connection)
+	if err != nil {
+		return config.Config{}, err
+	}
+	jobConfig.PostgresURLBaseDB = connURL
+	return jobConfig, nil
+}
+
+func connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(registry.List())
+}
+
+// testConnectionHandler serves POST /api/connections/{name}/test.
+func testConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/connections/"), "/test")
+	if !ok || name == "" {
+		http.Error(w, "Connection name required", http.StatusBadRequest)
+		return
+	}
+	if _, err := registry.Get(name); err != nil {
+		http.Error(w, "Connection not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	latency, err := registry.Test(ctx, name)
+
+	result := map[string]interface{}{"name": name, "ok": err == nil}
+	if err != nil {
+		result["error"] = err.Error()
+	} else {
+		result["latency_ms"] = latency.Milliseconds()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func connectionErrorStatus(err error) int {
+	if errors.Is(err, connections.ErrUnknownConnection) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"xlsxtoSQL/auth"
+	"xlsxtoSQL/processXlsx"
+)
+
+// exportHandler serves POST /api/export. It answers with an .xlsx workbook of
+// the requested tables and queries of a schema. Arbitrary queries need the
+// admin role; exporting tables only needs operator.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		processXlsx.ExportRequest
+		Connection  string `json:"connection"`
+		PostgresURL string `json:"postgres_url"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Schema == "" {
+		http.Error(w, "Schema required", http.StatusBadRequest)
+		return
+	}
+	if principal, _ := auth.FromContext(r.Context()); len(req.Queries) > 0 && principal.Role < auth.RoleAdmin {
+		http.Error(w, "Forbidden: queries require role admin", http.StatusForbidden)
+		return
+	}
+
+	job := jobRequest{display: map[string]interface{}{}}
+	if status, err := setConnection(&job, req.Connection, req.PostgresURL); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	runCfg, err := runtimeConfig(job.config)
+	if err != nil {
+		http.Error(w, err.Error(), connectionErrorStatus(err))
+		return
+	}
+
+	// The workbook is built in memory so that a failing query is still
+	// answered with an error status.
+	var buf bytes.Buffer
+	sheets, err := processXlsx.Export(r.Context(), runCfg, req.ExportRequest, &buf)
+	if err != nil {
+		slog.Warn("export failed", "schema", req.Schema, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, sheet := range sheets {
+		slog.Info("sheet exported", "schema", req.Schema, "sheet", sheet.Sheet, "rows", sheet.Rows)
+	}
+
+	name := exportFileName(req.Schema)
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Write(buf.Bytes())
+}
+
+// exportFileName derives a download name from a schema, which is usually the
+// path of the workbook it was loaded from.
+func exportFileName(schema string) string {
+	name := schema[strings.LastIndexAny(schema, `/\`)+1:]
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == '"' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSuffix(name, ".xlsx")
+	if name == "" {
+		return "export.xlsx"
+	}
+	return name + ".xlsx"
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func Test_exportFileName(t *testing.T) {
+	tests := []struct {
+		schema, want string
+	}{
+		{"report", "report.xlsx"},
+		{"data/MOCK_DATA.xlsx", "MOCK_DATA.xlsx"},
+		{`C:\files\budget.xlsx`, "budget.xlsx"},
+		{"dir/", "export.xlsx"},
+		{"", "export.xlsx"},
+		{".xlsx", "export.xlsx"},
+		{"say \"hi\"\n", "say _hi__.xlsx"},
+	}
+	for _, tt := range tests {
+		if got := exportFileName(tt.schema); got != tt.want {
+			t.Errorf("exportFileName(%q) = %q, want %q", tt.schema, got, tt.want)
+		}
+	}
+}
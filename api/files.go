@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"xlsxtoSQL/processXlsx"
+	"xlsxtoSQL/uploads"
+)
+
+var fileStore *uploads.Store
+
+// filesHandler serves the collection endpoint: POST uploads a workbook, GET
+// lists the uploaded ones.
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		uploadFile(w, r)
+	case http.MethodGet:
+		listFiles(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// fileHandler serves a single uploaded file: GET downloads it, DELETE removes
+// it, GET /api/files/{id}/preview describes its sheets.
+func fileHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	if id == "" {
+		http.Error(w, "File ID required", http.StatusBadRequest)
+		return
+	}
+	if id, ok := strings.CutSuffix(id, "/preview"); ok {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		previewFile(w, r, id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		downloadFile(w, r, id)
+	case http.MethodDelete:
+		deleteFile(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func uploadFile(w http.ResponseWriter, r *http.Request) {
+	// Leave some room for the multipart framing around the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, serverCfg.MaxUploadMB<<20+1<<20)
+
+	part, header, err := r.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, uploads.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Multipart field \"file\" required", http.StatusBadRequest)
+		return
+	}
+	defer part.Close()
+
+	file, duplicate, err := fileStore.Save(header.Filename, header.Header.Get("Content-Type"), part)
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, uploads.ErrTooLarge), errors.As(err, &maxErr):
+		http.Error(w, uploads.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, uploads.ErrNotWorkbook):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to store file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !duplicate {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"file": file, "duplicate": duplicate})
+}
+
+func listFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := fileStore.List()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list files: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+func downloadFile(w http.ResponseWriter, r *http.Request, id string) {
+	file, err := fileStore.Get(id)
+	if errors.Is(err, uploads.ErrNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	path, err := fileStore.Path(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	http.ServeContent(w, r, file.Name, file.UploadedAt, f)
+}
+
+func deleteFile(w http.ResponseWriter, r *http.Request, id string) {
+	path, err := fileStore.Path(id)
+	if errors.Is(err, uploads.ErrNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if resolved, err := fileResolver.Resolve(path); err == nil {
+		path = resolved
+	}
+
+	mutex.Lock()
+	for _, inst := range instances {
+		for _, p := range inst.JobConfig.ExcelFilePaths {
+			if p == path {
+				mutex.Unlock()
+				http.Error(w, fmt.Sprintf("File is used by instance %s", inst.ID), http.StatusConflict)
+				return
+			}
+		}
+	}
+	mutex.Unlock()
+
+	if err := fileStore.Delete(id); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": id, "status": "deleted"})
+}
+
+// previewFile lists the sheets of an uploaded workbook with their header,
+// inferred column types and the first rows (?rows=N, default 10, at most 100).
+func previewFile(w http.ResponseWriter, r *http.Request, id string) {
+	rows := 10
+	if v := r.URL.Query().Get("rows"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			http.Error(w, "Invalid rows", http.StatusBadRequest)
+			return
+		}
+		rows = n
+	}
+
+	path, err := fileStore.Path(id)
+	if errors.Is(err, uploads.ErrNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sheets, err := processXlsx.PreviewExcelFile(path, rows)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read workbook: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "sheets": sheets})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"xlsxtoSQL/joblog"
+)
+
+// sseHeartbeat keeps idle event streams from being closed by proxies.
+const sseHeartbeat = 15 * time.Second
+
+func logPath(id string) string {
+	return filepath.Join(serverCfg.LogDir, id+".log")
+}
+
+// openLogs attaches the log buffer of an instance, reloading what an earlier
+// server process wrote to its log file.
+func openLogs(inst *Instance) {
+	buffer, err := joblog.NewBuffer(serverCfg.LogBufferSize, logPath(inst.ID))
+	if err != nil {
+		slog.Error("failed to open instance log, keeping it in memory only", "instance", inst.ID, "error", err)
+		buffer, _ = joblog.NewBuffer(serverCfg.LogBufferSize, "")
+	}
+	inst.logs = buffer
+}
+
+func removeLogs(inst *Instance) {
+	if inst.logs != nil {
+		inst.logs.Close()
+	}
+	os.Remove(logPath(inst.ID))
+}
+
+// logsHandler serves GET /api/logs/{id}?tail=N&level=LEVEL&follow=1. With
+// follow the tail and every new entry are streamed as Server-Sent Events.
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/logs/")
+	if id == "" {
+		http.Error(w, "Instance ID required", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	tail := 100
+	if v := query.Get("tail"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid tail", http.StatusBadRequest)
+			return
+		}
+		tail = n
+	}
+	minLevel := slog.LevelDebug
+	if v := query.Get("level"); v != "" {
+		level, err := joblog.ParseLevel(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		minLevel = level
+	}
+	follow := query.Get("follow") == "1" || query.Get("follow") == "true"
+
+	mutex.Lock()
+	inst, exists := instances[id]
+	var logs *joblog.Buffer
+	if exists {
+		logs = inst.logs
+	}
+	mutex.Unlock()
+	if !exists {
+		http.Error(w, "Instance not found", http.StatusNotFound)
+		return
+	}
+
+	if !follow {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logs.Tail(tail, minLevel))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before sending the tail so that nothing logged in between
+	// is lost; entries already sent are skipped by their sequence number.
+	entries, unsubscribe := logs.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var lastSeq int64
+	for _, entry := range logs.Tail(tail, minLevel) {
+		writeEvent(w, "log", entry.Seq, entry)
+		lastSeq = entry.Seq
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-serverCtx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case entry := <-entries:
+			if entry.Seq <= lastSeq || joblog.LevelOf(entry) < minLevel {
+				continue
+			}
+			writeEvent(w, "log", entry.Seq, entry)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, id int64, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, payload)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"xlsxtoSQL/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var jobStatesDesc = prometheus.NewDesc("xlsxtosql_jobs",
+	"Instances by status.", []string{"status"}, nil)
+
+// jobStates exports the number of instances in each status.
+type jobStates struct{}
+
+func (jobStates) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobStatesDesc
+}
+
+func (jobStates) Collect(ch chan<- prometheus.Metric) {
+	counts := map[string]int{"running": 0, "finished": 0, "failed": 0, "stopped": 0}
+	mutex.Lock()
+	for _, inst := range instances {
+		counts[inst.Status]++
+	}
+	mutex.Unlock()
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(jobStatesDesc, prometheus.GaugeValue, float64(n), status)
+	}
+}
+
+func init() {
+	metrics.Registry.MustRegister(jobStates{})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"xlsxtoSQL/processXlsx"
+	"xlsxtoSQL/store"
+)
+
+// jobStore persists instances and their run history. It is nil when no
+// control database is configured, in which case everything stays in memory.
+var jobStore *store.Store
+
+func persistJob(inst *Instance) error {
+	if jobStore == nil {
+		return nil
+	}
+	return jobStore.SaveJob(context.Background(), store.Job{
+		ID:      inst.ID,
+		Config:  inst.JobConfig,
+		Display: inst.Config,
+		Once:    inst.Once,
+		Status:  "running",
+	})
+}
+
+func persistStatus(id, status string) {
+	if jobStore == nil {
+		return
+	}
+	if err := jobStore.SetStatus(context.Background(), id, status); err != nil {
+		slog.Error("failed to save instance status", "instance", id, "error", err)
+	}
+}
+
+func persistRun(id string, run processXlsx.Run) {
+	if jobStore == nil {
+		return
+	}
+	if err := jobStore.AddRun(context.Background(), id, run); err != nil {
+		slog.Error("failed to save run", "instance", id, "run", run.Number, "error", err)
+	}
+}
+
+func forgetJob(id string) error {
+	if jobStore == nil {
+		return nil
+	}
+	return jobStore.DeleteJob(context.Background(), id)
+}
+
+// restoreInstances loads the stored jobs into instances. Recurring jobs that
+// were running when the server went down are started again; one-off jobs
+// that were interrupted are marked as stopped.
+func restoreInstances() error {
+	jobs, err := jobStore.Jobs(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// The statuses of interrupted jobs are saved once mutex is released, so
+	// that a slow control database does not hold up the handlers.
+	var interrupted []string
+	defer func() {
+		for _, id := range interrupted {
+			persistStatus(id, "stopped")
+		}
+	}()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, job := range jobs {
+		inst := &Instance{
+			ID:        job.ID,
+			Config:    job.Display,
+			Status:    job.Status,
+			JobConfig: job.Config,
+			Once:      job.Once,
+			lifecycle: &sync.Mutex{},
+		}
+		openLogs(inst)
+		instances[job.ID] = inst
+
+		if n, err := strconv.Atoi(strings.TrimPrefix(job.ID, "instance_")); err == nil && n > counter {
+			counter = n
+		}
+
+		if job.Status != "running" {
+			continue
+		}
+		if job.Once {
+			inst.Status = "stopped"
+			inst.Error = "interrupted by server restart"
+			interrupted = append(interrupted, job.ID)
+			continue
+		}
+		slog.Info("resuming instance", "instance", job.ID)
+		startInstance(inst)
+	}
+	slog.Info("instances restored", "count", len(jobs))
+	return nil
+}
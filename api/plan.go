@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"xlsxtoSQL/processXlsx"
+)
+
+// planHandler serves POST /api/plan. It takes the body of /api/start and
+// answers with what the job would change, without writing anything; add
+// format=text for the diff printed by the CLI. When a file cannot be planned
+// the plans are still sent, with its error, but with status 500.
+func planHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, status, err := parseJobRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	runCfg, err := runtimeConfig(job.config)
+	if err != nil {
+		http.Error(w, err.Error(), connectionErrorStatus(err))
+		return
+	}
+
+	plans := []processXlsx.Plan{}
+	failed := false
+	for _, file := range runCfg.ExcelFilePaths {
+		plan, err := processXlsx.PlanExcelFile(r.Context(), runCfg, file)
+		if err != nil {
+			failed = true
+		}
+		plans = append(plans, plan)
+	}
+
+	status = http.StatusOK
+	if failed {
+		status = http.StatusInternalServerError
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		for _, plan := range plans {
+			plan.WriteText(w)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(plans)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"xlsxtoSQL/processXlsx"
+)
+
+// retryHandler serves POST /api/retry/{id}?sheet=NAME, re-attempting the
+// quarantined rows of every workbook of an instance, or of one sheet only.
+func retryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/retry/")
+	if id == "" {
+		http.Error(w, "Instance ID required", http.StatusBadRequest)
+		return
+	}
+
+	mutex.Lock()
+	instance, exists := instances[id]
+	mutex.Unlock()
+	if !exists {
+		http.Error(w, "Instance not found", http.StatusNotFound)
+		return
+	}
+
+	runCfg, err := runtimeConfig(instance.JobConfig)
+	if err != nil {
+		http.Error(w, err.Error(), connectionErrorStatus(err))
+		return
+	}
+
+	jobLogger := slog.New(instance.logs.Handler(slog.Default().Handler())).With("instance", id)
+	ctx := processXlsx.WithLogger(r.Context(), jobLogger)
+	sheet := r.URL.Query().Get("sheet")
+
+	results := []processXlsx.RetryResult{}
+	for _, file := range runCfg.ExcelFilePaths {
+		result, err := processXlsx.RetryRejects(ctx, runCfg, file, sheet)
+		if err != nil {
+			jobLogger.Error("failed to retry quarantined rows", "file", file, "error", err)
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"xlsxtoSQL/processXlsx"
+)
+
+// ReportInfo describes a stored load report.
+type ReportInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+func reportDir(id string) string {
+	return filepath.Join(serverCfg.ReportDir, id)
+}
+
+// writeReport stores the report of a finished run. Reports are kept on disk
+// so they survive restarts and can be downloaded after the instance is gone
+// from memory.
+func writeReport(id string, run processXlsx.Run) {
+	if serverCfg.ReportDir == "" {
+		return
+	}
+	if _, err := run.WriteReport(reportDir(id)); err != nil {
+		slog.Error("failed to write load report", "instance", id, "error", err)
+	}
+}
+
+func removeReports(id string) {
+	if serverCfg.ReportDir != "" {
+		os.RemoveAll(reportDir(id))
+	}
+}
+
+// listReports returns the reports of an instance, newest first.
+func listReports(id string) ([]ReportInfo, error) {
+	entries, err := os.ReadDir(reportDir(id))
+	if os.IsNotExist(err) {
+		return []ReportInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	reports := []ReportInfo{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		reports = append(reports, ReportInfo{Name: e.Name(), Size: info.Size(), Created: info.ModTime()})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name > reports[j].Name })
+	return reports, nil
+}
+
+// reportsHandler serves GET /api/reports/{id} listing the reports of an
+// instance and GET /api/reports/{id}/{name} downloading one of them. The
+// name "latest" selects the newest report.
+func reportsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if serverCfg.ReportDir == "" {
+		http.Error(w, "Reports are disabled", http.StatusNotFound)
+		return
+	}
+
+	id, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/reports/"), "/")
+	if id == "" || !filepath.IsLocal(id) || strings.ContainsAny(id, `/\`) {
+		http.Error(w, "Instance ID required", http.StatusBadRequest)
+		return
+	}
+
+	reports, err := listReports(id)
+	if err != nil {
+		http.Error(w, "Failed to list reports", http.StatusInternalServerError)
+		return
+	}
+
+	if name == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+		return
+	}
+
+	if name == "latest" {
+		if len(reports) == 0 {
+			http.Error(w, "Report not found", http.StatusNotFound)
+			return
+		}
+		name = reports[0].Name
+	}
+	if !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) || !strings.HasSuffix(name, ".json") {
+		http.Error(w, "Invalid report name", http.StatusBadRequest)
+		return
+	}
+
+	path := filepath.Join(reportDir(id), name)
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("download") == "1" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+id+"-"+name+`"`)
+	}
+	http.ServeFile(w, r, path)
+}
@@ -1,34 +1,82 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
-
+	"xlsxtoSQL/auth"
+	"xlsxtoSQL/config"
+	"xlsxtoSQL/connections"
+	"xlsxtoSQL/fileref"
+	"xlsxtoSQL/joblog"
+	"xlsxtoSQL/logging"
+	"xlsxtoSQL/metrics"
+	"xlsxtoSQL/processXlsx"
+	"xlsxtoSQL/rules"
+	"xlsxtoSQL/store"
+	"xlsxtoSQL/tracing"
+	"xlsxtoSQL/uploads"
+
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v2"
 )
 
 type Instance struct {
-	ID         string    `json:"id"`
-	Config     string    `json:"config"`
-	Status     string    `json:"status"`
-	StartedAt  time.Time `json:"started_at"`
-	Process    *exec.Cmd `json:"-"`
-	ConfigPath string    `json:"-"`
+	ID         string                `json:"id"`
+	Config     string                `json:"config"`
+	Status     string                `json:"status"`
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt time.Time             `json:"finished_at,omitempty"`
+	Error      string                `json:"error,omitempty"`
+	Runs       int                   `json:"runs"`
+	Results    []processXlsx.Result  `json:"results"`
+	Progress   *processXlsx.Progress `json:"progress,omitempty"`
+	Locks      []processXlsx.Lock    `json:"locks,omitempty"`
+	// Files is the progress of each file loaded in parallel, Progress the
+	// latest of them.
+	Files     []processXlsx.Progress `json:"files,omitempty"`
+	JobConfig config.Config          `json:"-"`
+	Once      bool                   `json:"-"`
+	runner    *processXlsx.Runner
+	logs      *joblog.Buffer
+	// traceLink is the span of the request that created the instance.
+	traceLink trace.SpanContext
+	cancel    context.CancelFunc
+	done      chan struct{}
+	// lifecycle serialises the stops, restarts and deletes of the instance.
+	lifecycle *sync.Mutex
 }
 
+// stopTimeout is how long a stop, restart or delete waits for the running
+// sheet to roll back before giving up on the job.
+const stopTimeout = 30 * time.Second
+
+// statusPollInterval is how often a followed status is checked for changes.
+const statusPollInterval = time.Second
+
 var (
 	instances = make(map[string]*Instance)
 	mutex     sync.Mutex
 	counter   int
+	serverCfg *config.ServerConfig
+	// fileResolver confines every workbook reference to the allowed roots.
+	fileResolver *fileref.Resolver
+	// serverCtx is the parent of every job context and is cancelled on shutdown.
+	serverCtx, cancelServer = context.WithCancel(context.Background())
 )
 
 func extractDatabaseName(postgresURL string) string {
@@ -40,26 +88,135 @@ func extractDatabaseName(postgresURL string) string {
 }
 
 func main() {
-	http.HandleFunc("/api/start", withCORS(startHandler))
-	http.HandleFunc("/api/stop/", withCORS(stopHandler))
-	http.HandleFunc("/api/restart/", withCORS(restartHandler))
-	http.HandleFunc("/api/delete/", withCORS(deleteHandler))
-	http.HandleFunc("/api/instances", withCORS(instancesHandler))
-	http.HandleFunc("/api/status/", withCORS(statusHandler))
-	http.HandleFunc("/api/isalife/", withCORS(isalive))
+	configPath := flag.String("config", "server.yaml", "path to the server config file")
+	flag.Parse()
+
+	var err error
+	serverCfg, err = config.LoadServerConfig(*configPath)
+	if err != nil {
+		fatal("failed to load server config", err)
+	}
+	if err := logging.Setup(os.Stderr, serverCfg.LogFormat, serverCfg.LogLevel); err != nil {
+		fatal("failed to set up logging", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(serverCtx, serverCfg.Tracing, "xlsxtosql-api")
+	if err != nil {
+		fatal("failed to set up tracing", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	fileStore, err = uploads.NewStore(serverCfg.UploadDir, serverCfg.MaxUploadMB<<20)
+	if err != nil {
+		fatal("failed to open upload directory", err)
+	}
+	if err := os.MkdirAll(serverCfg.LogDir, 0o750); err != nil {
+		fatal("failed to create log directory", err)
+	}
 
-	log.Println("API started on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	fileResolver, err = fileref.NewResolver(serverCfg.FileRoots())
+	if err != nil {
+		fatal("failed to set up allowed roots", err)
+	}
+
+	if serverCfg.ControlDBURL != "" {
+		jobStore, err = store.Open(serverCtx, serverCfg.ControlDBURL)
+		if err != nil {
+			fatal("failed to open job store", err)
+		}
+		defer jobStore.Close()
+		if err := restoreInstances(); err != nil {
+			fatal("failed to restore instances", err)
+		}
+	} else {
+		slog.Warn("control_db_url is not set, instances will not survive a restart")
 	}
+
+	registry, err = connections.NewRegistry(serverCfg.Connections, serverCfg.SecretsFile)
+	if err != nil {
+		fatal("failed to load connections", err)
+	}
+
+	if err := setupAuth(serverCfg.Auth); err != nil {
+		fatal("failed to set up authentication", err)
+	}
+
+	viewer := byMethod{http.MethodGet: auth.RoleViewer}
+	operator := byMethod{http.MethodPost: auth.RoleOperator}
+	admin := byMethod{http.MethodPost: auth.RoleAdmin}
+
+	http.HandleFunc("/api/start", withCORS(withAuth(operator, startHandler)))
+	http.HandleFunc("/api/stop/", withCORS(withAuth(operator, stopHandler)))
+	http.HandleFunc("/api/restart/", withCORS(withAuth(operator, restartHandler)))
+	http.HandleFunc("/api/delete/", withCORS(withAuth(admin, deleteHandler)))
+	http.HandleFunc("/api/instances", withCORS(withAuth(viewer, instancesHandler)))
+	http.HandleFunc("/api/status/", withCORS(withAuth(viewer, statusHandler)))
+	http.HandleFunc("/api/history/", withCORS(withAuth(viewer, historyHandler)))
+	http.HandleFunc("/api/logs/", withCORS(withAuth(viewer, logsHandler)))
+	http.HandleFunc("/api/reports/", withCORS(withAuth(viewer, reportsHandler)))
+	http.HandleFunc("/api/plan", withCORS(withAuth(operator, planHandler)))
+	http.HandleFunc("/api/retry/", withCORS(withAuth(operator, retryHandler)))
+	http.HandleFunc("/api/export", withCORS(withAuth(operator, exportHandler)))
+	http.HandleFunc("/api/config/validate", withCORS(withAuth(operator, validateConfigHandler)))
+	http.HandleFunc("/api/files", withCORS(withAuth(byMethod{
+		http.MethodGet:  auth.RoleViewer,
+		http.MethodPost: auth.RoleOperator,
+	}, filesHandler)))
+	http.HandleFunc("/api/files/", withCORS(withAuth(byMethod{
+		http.MethodGet:    auth.RoleViewer,
+		http.MethodDelete: auth.RoleAdmin,
+	}, fileHandler)))
+	http.HandleFunc("/api/connections", withCORS(withAuth(viewer, connectionsHandler)))
+	http.HandleFunc("/api/connections/", withCORS(withAuth(operator, testConnectionHandler)))
+	http.HandleFunc("/api/whoami", withCORS(withAuth(viewer, whoamiHandler)))
+	http.HandleFunc("/metrics", withAuth(viewer, metrics.Handler().ServeHTTP))
+	http.HandleFunc("/api/isalife/", withCORS(isalive))
+	http.HandleFunc("/api/isalive", withCORS(isalive))
+
+	server := &http.Server{Addr: serverCfg.ListenAddr, Handler: tracing.Middleware(http.DefaultServeMux)}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-sigCtx.Done()
+		slog.Info("shutdown signal received, stopping instances")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+		stopAll()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("API started", "addr", serverCfg.ListenAddr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fatal("failed to start server", err)
+	}
+	stopAll()
 }
 
+// fatal logs err and exits. It is only used while the server starts.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// withCORS answers browser preflight requests and allows the origins listed
+// in cors_origins to read responses.
 func withCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			w.Header().Add("Vary", "Origin")
+			if corsAllowed(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			}
+		}
 		if r.Method == http.MethodOptions {
+			if origin != "" && !corsAllowed(origin) {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -67,90 +224,398 @@ func withCORS(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+func corsAllowed(origin string) bool {
+	for _, allowed := range serverCfg.CORSOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// startInstance runs the instance's loader in a goroutine with its own
+// context. The caller must hold mutex.
+func startInstance(inst *Instance) {
+	runCfg, err := runtimeConfig(inst.JobConfig)
+	if err != nil {
+		slog.New(inst.logs.Handler(slog.Default().Handler())).Error("failed to start instance", "instance", inst.ID, "error", err)
+		inst.Status = "failed"
+		inst.Error = err.Error()
+		inst.FinishedAt = time.Now()
+		go persistStatus(inst.ID, inst.Status)
+		return
+	}
+
+	jobLogger := slog.New(inst.logs.Handler(slog.Default().Handler())).With("instance", inst.ID)
+	ctx, cancel := context.WithCancel(processXlsx.WithLogger(tracing.WithLink(serverCtx, inst.traceLink), jobLogger))
+	runner := processXlsx.NewRunner(runCfg, inst.Once)
+	done := make(chan struct{})
+
+	inst.runner = runner
+	inst.cancel = cancel
+	inst.done = done
+	inst.Status = "running"
+	inst.StartedAt = time.Now()
+	inst.FinishedAt = time.Time{}
+	inst.Error = ""
+
+	runner.OnRunDone = func(run processXlsx.Run) {
+		persistRun(inst.ID, run)
+		writeReport(inst.ID, run)
+	}
+
+	go func() {
+		defer close(done)
+		defer cancel()
+		persistStatus(inst.ID, "running")
+		jobLogger.Info("instance started", "once", inst.Once)
+		err := runJob(ctx, runner, jobLogger)
+
+		mutex.Lock()
+		if inst.runner != runner {
+			mutex.Unlock()
+			return
+		}
+		inst.FinishedAt = time.Now()
+		switch {
+		case ctx.Err() != nil:
+			inst.Status = "stopped"
+		case err != nil:
+			inst.Status = "failed"
+			inst.Error = err.Error()
+		case failedResult(runner.Results()) != "":
+			inst.Status = "failed"
+			inst.Error = failedResult(runner.Results())
+		default:
+			inst.Status = "finished"
+		}
+		status, statusErr := inst.Status, inst.Error
+		mutex.Unlock()
+
+		jobLogger.Info("instance "+status, "error", statusErr)
+		// A job interrupted by server shutdown keeps its stored status so
+		// that it is resumed on the next start. The control database is
+		// written without holding mutex: it may be slow to answer.
+		if serverCtx.Err() == nil {
+			persistStatus(inst.ID, status)
+		}
+	}()
+}
+
+// stopInstance cancels the instance's job and waits up to stopTimeout for the
+// sheet in progress to roll back. A job that does not stop in time is
+// abandoned: the instance is marked failed and may be restarted or deleted,
+// while the job goroutine is left to finish on its own. The caller must not
+// hold mutex.
+func stopInstance(inst *Instance) error {
+	mutex.Lock()
+	cancel, done := inst.cancel, inst.done
+	mutex.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(stopTimeout):
+		abandonInstance(inst, done)
+		return fmt.Errorf("instance %s did not stop within %s, its job was abandoned", inst.ID, stopTimeout)
+	}
+}
+
+// abandonInstance detaches the job signalled by done from inst, unless it
+// finished or was replaced in the meantime.
+func abandonInstance(inst *Instance, done chan struct{}) {
+	mutex.Lock()
+	if inst.done != done {
+		mutex.Unlock()
+		return
+	}
+	inst.runner, inst.cancel, inst.done = nil, nil, nil
+	inst.Status = "failed"
+	inst.Error = fmt.Sprintf("did not stop within %s, its job was abandoned", stopTimeout)
+	inst.FinishedAt = time.Now()
+	mutex.Unlock()
+
+	slog.Error("abandoned instance job that did not stop, it keeps running until it notices the cancellation", "instance", inst.ID, "timeout", stopTimeout)
+	if serverCtx.Err() == nil {
+		persistStatus(inst.ID, "failed")
+	}
+}
+
+func stopAll() {
+	cancelServer()
+	mutex.Lock()
+	list := make([]*Instance, 0, len(instances))
+	for _, inst := range instances {
+		list = append(list, inst)
+	}
+	mutex.Unlock()
+
+	for _, inst := range list {
+		if err := stopInstance(inst); err != nil {
+			slog.Error("failed to stop instance", "instance", inst.ID, "error", err)
+		}
+	}
+}
+
+// runJob runs runner until ctx is done and reports a panic of the runner as
+// its error, so that the instance fails instead of the server.
+func runJob(ctx context.Context, runner *processXlsx.Runner, jobLogger *slog.Logger) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			jobLogger.Error("instance panicked", "panic", p, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return runner.Run(ctx)
+}
+
+func failedResult(results []processXlsx.Result) string {
+	for _, result := range results {
+		if result.Error != "" {
+			return fmt.Sprintf("%s: %s", result.File, result.Error)
+		}
+	}
+	return ""
+}
+
+// snapshot copies the public fields of inst. The caller must hold mutex.
+func snapshot(inst *Instance) Instance {
+	result := Instance{
+		ID:         inst.ID,
+		Config:     inst.Config,
+		Status:     inst.Status,
+		StartedAt:  inst.StartedAt,
+		FinishedAt: inst.FinishedAt,
+		Error:      inst.Error,
+		Results:    []processXlsx.Result{},
+	}
+	if inst.runner != nil {
+		result.Runs = inst.runner.Runs()
+		result.Results = inst.runner.Results()
+		if progress, ok := inst.runner.Progress(); ok {
+			result.Progress = &progress
+			result.Files = inst.runner.FileProgress()
+		}
+		result.Locks = inst.runner.Locks()
+	}
+	return result
+}
+
+// pathErrorStatus maps a rejected file reference to its HTTP status.
+func pathErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, fileref.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, fileref.ErrInvalidPath), errors.Is(err, fileref.ErrNotFound):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func isalive(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Server is alive")
 }
 
-func startHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// jobRequest is a job described by a /api/start or /api/plan request body.
+type jobRequest struct {
+	config  config.Config
+	display map[string]interface{}
+	once    bool
+}
 
+// parseJobRequest reads and checks the body of a job request. On error it
+// also returns the HTTP status to answer with.
+func parseJobRequest(r *http.Request) (jobRequest, int, error) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
+		return jobRequest{}, http.StatusBadRequest, errors.New("Invalid request")
 	}
 
 	var req struct {
-		ExcelFileName   string   `json:"excel_file_name"`
-		PostgresURL     string   `json:"postgres_url"`
-		IgnorantSheets  []string `json:"ignorant_sheets"`
-		Once            bool     `json:"once"`
-		IntervalSeconds int      `json:"interval_seconds"`
+		ExcelFileName   string       `json:"excel_file_name"`
+		FileID          string       `json:"file_id"`
+		Schema          string       `json:"schema"`
+		Connection      string       `json:"connection"`
+		PostgresURL     string       `json:"postgres_url"`
+		IgnorantSheets  []string     `json:"ignorant_sheets"`
+		Once            bool         `json:"once"`
+		IntervalSeconds int          `json:"interval_seconds"`
+		Rules           []rules.Rule `json:"rules"`
+		LineageColumns  []string     `json:"lineage_columns"`
+		LoadMode        string       `json:"load_mode"`
+		NaturalKey      []string     `json:"natural_key"`
+		SheetWorkers    int          `json:"sheet_workers"`
+		LockScope       string       `json:"lock_scope"`
+		LockPolicy      string       `json:"lock_policy"`
+		LockTimeout     float64      `json:"lock_timeout_seconds"`
 	}
 	if err := json.Unmarshal(body, &req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
+		return jobRequest{}, http.StatusBadRequest, errors.New("Invalid JSON")
 	}
 
-	excelFilePath := fmt.Sprintf("/app/data/%s", req.ExcelFileName)
-
-	config := map[string]interface{}{
-		"excel_file_paths":     []string{excelFilePath},
-		"postgres_url_base_db": req.PostgresURL,
-		"interval_seconds":     req.IntervalSeconds,
-		"ignorant_sheets":      req.IgnorantSheets,
+	fileName, schema := req.ExcelFileName, req.Schema
+	if req.FileID != "" {
+		upload, err := fileStore.Get(req.FileID)
+		if err == nil {
+			fileName, err = fileStore.Path(req.FileID)
+		}
+		if errors.Is(err, uploads.ErrNotFound) {
+			return jobRequest{}, http.StatusBadRequest, errors.New("Uploaded file not found")
+		}
+		if err != nil {
+			return jobRequest{}, http.StatusInternalServerError, fmt.Errorf("Failed to read uploaded file: %v", err)
+		}
+		// The stored path changes with the content of the upload: a new
+		// version of the workbook must load into the same schema.
+		if schema == "" {
+			schema = upload.Name
+		}
 	}
-	configYAML, err := yaml.Marshal(config)
+	excelFilePath, err := fileResolver.Resolve(fileName)
 	if err != nil {
-		http.Error(w, "Failed to generate YAML config", http.StatusInternalServerError)
+		return jobRequest{}, pathErrorStatus(err), err
+	}
+
+	job := jobRequest{
+		config: config.Config{
+			ExcelFilePaths:  []string{excelFilePath},
+			IntervalSeconds: req.IntervalSeconds,
+			IgnorantSheets:  req.IgnorantSheets,
+			AllowedRoots:    fileResolver.Roots(),
+			Rules:           req.Rules,
+			LineageColumns:  req.LineageColumns,
+			LoadMode:        req.LoadMode,
+			NaturalKey:      req.NaturalKey,
+			SheetWorkers:    req.SheetWorkers,
+			Schema:          schema,
+			Lock: config.LockConfig{
+				Scope:   req.LockScope,
+				Policy:  req.LockPolicy,
+				Timeout: time.Duration(req.LockTimeout * float64(time.Second)),
+			},
+		},
+		display: map[string]interface{}{
+			"excel_file_paths": []string{excelFilePath},
+			"interval_seconds": req.IntervalSeconds,
+			"ignorant_sheets":  req.IgnorantSheets,
+		},
+		once: req.Once,
+	}
+	if err := job.config.Validate(); err != nil {
+		return jobRequest{}, http.StatusBadRequest, fmt.Errorf("Invalid job: %v", err)
+	}
+	if len(req.Rules) > 0 {
+		job.display["rules"] = req.Rules
+	}
+	if len(req.LineageColumns) > 0 {
+		job.display["lineage_columns"] = req.LineageColumns
+	}
+	if schema != "" {
+		job.display["schema"] = schema
+	}
+	if req.SheetWorkers > 0 {
+		job.display["sheet_workers"] = req.SheetWorkers
+	}
+	lock := map[string]interface{}{}
+	if req.LockScope != "" {
+		lock["scope"] = req.LockScope
+	}
+	if req.LockPolicy != "" {
+		lock["policy"] = req.LockPolicy
+	}
+	if req.LockTimeout > 0 {
+		lock["timeout"] = job.config.Lock.Timeout.String()
+	}
+	if len(lock) > 0 {
+		job.display["lock"] = lock
+	}
+	if req.LoadMode != "" {
+		job.display["load_mode"] = req.LoadMode
+		job.display["natural_key"] = req.NaturalKey
+	}
+
+	if status, err := setConnection(&job, req.Connection, req.PostgresURL); err != nil {
+		return jobRequest{}, status, err
+	}
+	return job, http.StatusOK, nil
+}
+
+// setConnection points a job at a named connection or, when the server allows
+// it, a raw PostgreSQL URL.
+func setConnection(job *jobRequest, connection, postgresURL string) (int, error) {
+	switch {
+	case connection != "":
+		conn, err := registry.Get(connection)
+		if err != nil {
+			return connectionErrorStatus(err), err
+		}
+		job.config.Connection = conn.Name
+		job.display["connection"] = conn.Name
+		job.display["database_name"] = conn.Database
+	case postgresURL != "" && serverCfg.AllowRawPostgresURLs:
+		job.config.PostgresURLBaseDB = postgresURL
+		job.display["database_name"] = extractDatabaseName(postgresURL)
+	case postgresURL != "":
+		return http.StatusBadRequest, errors.New("Raw postgres_url is disabled, use a named connection")
+	default:
+		return http.StatusBadRequest, errors.New("Connection required")
+	}
+	return http.StatusOK, nil
+}
+
+func startHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	displayConfig := map[string]interface{}{
-		"excel_file_paths": []string{excelFilePath},
-		"database_name":    extractDatabaseName(req.PostgresURL),
-		"interval_seconds": req.IntervalSeconds,
-		"ignorant_sheets":  req.IgnorantSheets,
+	job, status, err := parseJobRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	// A recurring job without an interval would reload without pause.
+	if !job.once && job.config.IntervalSeconds <= 0 {
+		http.Error(w, "Invalid job: interval_seconds must be positive unless once is set", http.StatusBadRequest)
+		return
 	}
-	displayConfigYAML, err := yaml.Marshal(displayConfig)
+	displayConfigYAML, err := yaml.Marshal(job.display)
 	if err != nil {
 		http.Error(w, "Failed to generate display YAML config", http.StatusInternalServerError)
 		return
 	}
 
+	mutex.Lock()
 	counter++
-	configPath := fmt.Sprintf("/tmp/config_%d.yaml", counter)
-	if err := os.WriteFile(configPath, configYAML, 0644); err != nil {
-		http.Error(w, "Failed to save config", http.StatusInternalServerError)
-		return
-	}
-
-	cmd := exec.Command("./main", "-config", configPath)
-	if req.Once {
-		cmd.Args = append(cmd.Args, "-once")
-	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	id := fmt.Sprintf("instance_%d", counter)
+	inst := &Instance{
+		ID:        id,
+		Config:    string(displayConfigYAML),
+		JobConfig: job.config,
+		Once:      job.once,
+		traceLink: trace.SpanContextFromContext(r.Context()),
+		lifecycle: &sync.Mutex{},
+	}
+	openLogs(inst)
+	instances[id] = inst
+	mutex.Unlock()
 
-	if err := cmd.Start(); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to start process: %v", err), http.StatusInternalServerError)
+	if err := persistJob(inst); err != nil {
+		mutex.Lock()
+		delete(instances, id)
+		mutex.Unlock()
+		http.Error(w, fmt.Sprintf("Failed to save instance: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	id := fmt.Sprintf("instance_%d", counter)
 	mutex.Lock()
-	instances[id] = &Instance{
-		ID:         id,
-		Config:     string(displayConfigYAML),
-		Status:     "running",
-		StartedAt:  time.Now(),
-		Process:    cmd,
-		ConfigPath: configPath,
-	}
+	startInstance(inst)
 	mutex.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -171,31 +636,18 @@ func stopHandler(w http.ResponseWriter, r *http.Request) {
 
 	mutex.Lock()
 	instance, exists := instances[id]
+	mutex.Unlock()
 	if !exists {
-		mutex.Unlock()
 		http.Error(w, "Instance not found", http.StatusNotFound)
 		return
 	}
+	instance.lifecycle.Lock()
+	defer instance.lifecycle.Unlock()
 
-	if instance.Status == "running" {
-		if err := instance.Process.Process.Kill(); err != nil {
-			mutex.Unlock()
-			http.Error(w, fmt.Sprintf("Failed to stop instance: %v", err), http.StatusInternalServerError)
-			return
-		}
-		if err := instance.Process.Wait(); err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
-				} else {
-					log.Printf("Unexpected error waiting after kill for instance %s: %v", id, err)
-				}
-			} else {
-				log.Printf("Unexpected error waiting after kill for instance %s: %v", id, err)
-			}
-		}
-		instance.Status = "stopped"
+	if err := stopInstance(instance); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to stop instance: %v", err), http.StatusInternalServerError)
+		return
 	}
-	mutex.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"id": id, "status": "stopped"})
@@ -215,46 +667,26 @@ func restartHandler(w http.ResponseWriter, r *http.Request) {
 
 	mutex.Lock()
 	instance, exists := instances[id]
+	mutex.Unlock()
 	if !exists {
-		mutex.Unlock()
 		http.Error(w, "Instance not found", http.StatusNotFound)
 		return
 	}
+	instance.lifecycle.Lock()
+	defer instance.lifecycle.Unlock()
 
-	if instance.Status == "running" {
-		if err := instance.Process.Process.Kill(); err != nil {
-			mutex.Unlock()
-			http.Error(w, fmt.Sprintf("Failed to stop instance for restart: %v", err), http.StatusInternalServerError)
-			return
-		}
-		if err := instance.Process.Wait(); err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
-				} else {
-					log.Printf("Unexpected error waiting after kill for instance %s: %v", id, err)
-				}
-			} else {
-				log.Printf("Unexpected error waiting after kill for instance %s: %v", id, err)
-			}
-		}
-	}
-
-	cmd := exec.Command("./main", "-config", instance.ConfigPath)
-	if strings.Contains(instance.Config, "interval_seconds: 0") {
-		cmd.Args = append(cmd.Args, "-once")
+	if err := stopInstance(instance); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to stop instance for restart: %v", err), http.StatusInternalServerError)
+		return
 	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
 
-	if err := cmd.Start(); err != nil {
+	mutex.Lock()
+	if _, exists := instances[id]; !exists {
 		mutex.Unlock()
-		http.Error(w, fmt.Sprintf("Failed to restart process: %v", err), http.StatusInternalServerError)
+		http.Error(w, "Instance not found", http.StatusNotFound)
 		return
 	}
-
-	instance.Process = cmd
-	instance.Status = "running"
-	instance.StartedAt = time.Now()
+	startInstance(instance)
 	mutex.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -275,33 +707,29 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 
 	mutex.Lock()
 	instance, exists := instances[id]
+	mutex.Unlock()
 	if !exists {
-		mutex.Unlock()
 		http.Error(w, "Instance not found", http.StatusNotFound)
 		return
 	}
+	instance.lifecycle.Lock()
+	defer instance.lifecycle.Unlock()
 
-	if instance.Status == "running" {
-		if err := instance.Process.Process.Kill(); err != nil {
-			mutex.Unlock()
-			http.Error(w, fmt.Sprintf("Failed to stop instance for deletion: %v", err), http.StatusInternalServerError)
-			return
-		}
-		if err := instance.Process.Wait(); err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
-				} else {
-					log.Printf("Unexpected error waiting after kill for instance %s: %v", id, err)
-				}
-			} else {
-				log.Printf("Unexpected error waiting after kill for instance %s: %v", id, err)
-			}
-		}
+	// An abandoned job does not keep the instance from being deleted.
+	if err := stopInstance(instance); err != nil {
+		slog.Warn("deleting instance whose job did not stop", "instance", id, "error", err)
 	}
 
-	os.Remove(instance.ConfigPath)
+	if err := forgetJob(id); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete instance: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	mutex.Lock()
 	delete(instances, id)
 	mutex.Unlock()
+	removeLogs(instance)
+	removeReports(id)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"id": id, "status": "deleted"})
@@ -318,21 +746,16 @@ func instancesHandler(w http.ResponseWriter, r *http.Request) {
 
 	result := make([]Instance, 0, len(instances))
 	for _, inst := range instances {
-		if inst.Status == "running" && inst.Process.ProcessState != nil && inst.Process.ProcessState.Exited() {
-			inst.Status = "stopped"
-		}
-		result = append(result, Instance{
-			ID:        inst.ID,
-			Config:    inst.Config,
-			Status:    inst.Status,
-			StartedAt: inst.StartedAt,
-		})
+		result = append(result, snapshot(inst))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
 
+// statusHandler serves GET /api/status/{id}. With follow=1 the status is
+// streamed as Server-Sent Events whenever it changes, until the instance is
+// no longer running.
 func statusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -345,23 +768,98 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	status, exists := instanceStatus(id)
+	if !exists {
+		http.Error(w, "Instance not found", http.StatusNotFound)
+		return
+	}
+
+	follow := r.URL.Query().Get("follow")
+	if follow != "1" && follow != "true" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var seq int64
+	var last []byte
+	ticker := time.NewTicker(statusPollInterval)
+	defer ticker.Stop()
+	for {
+		if payload, _ := json.Marshal(status); !bytes.Equal(payload, last) {
+			seq++
+			writeEvent(w, "status", seq, status)
+			flusher.Flush()
+			last = payload
+		}
+		if status.Status != "running" {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-serverCtx.Done():
+			return
+		case <-ticker.C:
+		}
+		if status, exists = instanceStatus(id); !exists {
+			return
+		}
+	}
+}
+
+func instanceStatus(id string) (Instance, bool) {
 	mutex.Lock()
+	defer mutex.Unlock()
 	inst, exists := instances[id]
-	mutex.Unlock()
 	if !exists {
-		http.Error(w, "Instance not found", http.StatusNotFound)
+		return Instance{}, false
+	}
+	return snapshot(inst), true
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if inst.Status == "running" && inst.Process.ProcessState != nil && inst.Process.ProcessState.Exited() {
-		inst.Status = "stopped"
+	id := strings.TrimPrefix(r.URL.Path, "/api/history/")
+	if id == "" {
+		http.Error(w, "Instance ID required", http.StatusBadRequest)
+		return
+	}
+	if jobStore == nil {
+		http.Error(w, "Run history is not enabled", http.StatusNotFound)
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	runs, err := jobStore.Runs(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load history: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":         inst.ID,
-		"status":     inst.Status,
-		"config":     inst.Config,
-		"started_at": inst.StartedAt,
-	})
+	json.NewEncoder(w).Encode(runs)
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func Test_abandonInstance(t *testing.T) {
+	done := make(chan struct{})
+	inst := &Instance{ID: "instance_1", Status: "running", cancel: func() {}, done: done, lifecycle: &sync.Mutex{}}
+
+	abandonInstance(inst, make(chan struct{}))
+	if inst.Status != "running" || inst.done != done {
+		t.Fatalf("abandonInstance with another job: status %q, want the instance untouched", inst.Status)
+	}
+
+	abandonInstance(inst, done)
+	if inst.Status != "failed" || inst.Error == "" {
+		t.Errorf("status = %q, error = %q, want failed with an error", inst.Status, inst.Error)
+	}
+	if inst.cancel != nil || inst.done != nil || inst.runner != nil {
+		t.Error("abandoned job is still attached to the instance")
+	}
+	if err := stopInstance(inst); err != nil {
+		t.Errorf("stopInstance after abandon = %v, want nil", err)
+	}
+}
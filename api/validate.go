@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"xlsxtoSQL/config"
+)
+
+// maxConfigBytes limits the size of a config sent for validation.
+const maxConfigBytes = 1 << 20
+
+// validateConfigHandler serves POST /api/config/validate. It takes a job
+// config as YAML and answers with every problem found in it, with their
+// lines. Workbooks are resolved like those of /api/start; with once=1 the
+// config does not need interval_seconds.
+func validateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigBytes))
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	_, problems := config.Check(data, config.CheckOptions{
+		Once:    r.URL.Query().Get("once") == "1",
+		Files:   true,
+		Resolve: fileResolver.Resolve,
+		Connection: func(name string) error {
+			_, err := registry.Get(name)
+			return err
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"valid":    len(problems) == 0,
+		"problems": append(config.Problems{}, problems...),
+	})
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials means the request carries no credentials for an
+	// authenticator, so the next one should be tried.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials means credentials were presented but rejected.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Role is the level of access granted to a principal. Higher roles include
+// every permission of the lower ones.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:     "none",
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func ParseRole(name string) (Role, error) {
+	for role, n := range roleNames {
+		if role != RoleNone && strings.EqualFold(n, strings.TrimSpace(name)) {
+			return role, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q", name)
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name   string `json:"name"`
+	Role   Role   `json:"-"`
+	Method string `json:"method"`
+}
+
+// Authenticator checks the credentials of a request. It returns
+// ErrNoCredentials when the request does not use its scheme.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Chain tries each authenticator in order and returns the first principal.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return nil, ErrNoCredentials
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored by WithPrincipal, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Token is a static API token accepted as "Authorization: Bearer <token>".
+type Token struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	Role  string `yaml:"role"`
+}
+
+type tokenEntry struct {
+	name  string
+	token []byte
+	role  Role
+}
+
+// TokenAuthenticator accepts a fixed set of bearer tokens.
+type TokenAuthenticator struct {
+	tokens []tokenEntry
+}
+
+func NewTokenAuthenticator(tokens []Token) (*TokenAuthenticator, error) {
+	a := &TokenAuthenticator{}
+	for _, t := range tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("token %q has no value", t.Name)
+		}
+		role, err := ParseRole(t.Role)
+		if err != nil {
+			return nil, fmt.Errorf("token %q: %w", t.Name, err)
+		}
+		a.tokens = append(a.tokens, tokenEntry{name: t.Name, token: []byte(t.Token), role: role})
+	}
+	return a, nil
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	// Three dot separated parts are a JWT, which is left to JWTAuthenticator.
+	if !ok || strings.Count(token, ".") == 2 {
+		return nil, ErrNoCredentials
+	}
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(t.token, []byte(token)) == 1 {
+			return &Principal{Name: t.name, Role: t.role, Method: "token"}, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// User is an entry of the users file used for HTTP basic auth.
+type User struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
+	Role         string `yaml:"role"`
+}
+
+type userEntry struct {
+	hash []byte
+	role Role
+}
+
+// BasicAuthenticator checks HTTP basic credentials against bcrypt hashes
+// loaded from a users file.
+type BasicAuthenticator struct {
+	users map[string]userEntry
+	// dummy is compared against for unknown users, so that they take as long
+	// to reject as a wrong password and do not reveal which users exist.
+	dummy []byte
+}
+
+// LoadUsersFile reads a YAML file with a top level "users" list.
+func LoadUsersFile(filename string) (*BasicAuthenticator, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+	var file struct {
+		Users []User `yaml:"users"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode users file: %w", err)
+	}
+
+	a := &BasicAuthenticator{users: make(map[string]userEntry, len(file.Users))}
+	// The dummy hash costs as much as the dearest user hash.
+	maxCost := bcrypt.MinCost
+	for _, u := range file.Users {
+		role, err := ParseRole(u.Role)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %w", u.Username, err)
+		}
+		cost, err := bcrypt.Cost([]byte(u.PasswordHash))
+		if err != nil {
+			return nil, fmt.Errorf("user %q: password_hash is not a bcrypt hash", u.Username)
+		}
+		if cost > maxCost {
+			maxCost = cost
+		}
+		a.users[u.Username] = userEntry{hash: []byte(u.PasswordHash), role: role}
+	}
+	a.dummy, err = bcrypt.GenerateFromPassword([]byte("xlsxtosql"), maxCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare password checks: %w", err)
+	}
+	return a, nil
+}
+
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	user, exists := a.users[username]
+	if !exists {
+		bcrypt.CompareHashAndPassword(a.dummy, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(user.hash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{Name: username, Role: user.role, Method: "basic"}, nil
+}
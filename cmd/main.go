@@ -1,43 +1,181 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"xlsxtoSQL/config"
+	"xlsxtoSQL/logging"
+	"xlsxtoSQL/metrics"
 	"xlsxtoSQL/processXlsx"
+	"xlsxtoSQL/tracing"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	configPath := flag.String("config", "config.yaml", "path to the config file")
 	once := flag.Bool("once", false, "run once and exit")
+	reportDir := flag.String("report-dir", "", "write a JSON load report of every run to this directory")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics on this address, e.g. :9100")
+	traceExporter := flag.String("trace-exporter", "", "export OpenTelemetry spans: otlp or stdout")
+	traceEndpoint := flag.String("trace-endpoint", "", "OTLP/HTTP collector endpoint, e.g. localhost:4318")
+	plan := flag.Bool("plan", false, "print what a load would change without writing anything and exit")
+	retry := flag.Bool("retry-rejects", false, "re-attempt the quarantined rows of the configured files and exit")
+	retrySheet := flag.String("sheet", "", "with -retry-rejects, only retry the rows of this sheet")
+	exportPath := flag.String("export", "", "export tables of the database to this .xlsx file and exit")
+	exportSchema := flag.String("export-schema", "", "with -export, the schema to export; defaults to the schema of the first configured file")
+	exportTables := flag.String("export-tables", "", "with -export, comma separated tables to export; defaults to all tables of the schema")
+	exportSync := flag.Bool("export-sync", false, "with -export, add hidden key and version columns so that loading the edited file applies only the changes")
+	var exportQueries []processXlsx.ExportQuery
+	flag.Func("export-query", "with -export, a query exported to a sheet, as sheet=SQL; repeatable", func(value string) error {
+		sheet, query, ok := strings.Cut(value, "=")
+		if !ok || strings.TrimSpace(query) == "" {
+			return errors.New("expected sheet=SQL")
+		}
+		exportQueries = append(exportQueries, processXlsx.ExportQuery{Sheet: strings.TrimSpace(sheet), SQL: query})
+		return nil
+	})
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
 	flag.Parse()
 
-	err := config.LoadConfig(*configPath)
+	if err := logging.Setup(os.Stderr, *logFormat, *logLevel); err != nil {
+		fatal("failed to set up logging", err)
+	}
+
+	if err := config.LoadConfig(*configPath); err != nil {
+		fatal("failed to load config", err)
+	}
+	cfg, err := config.GetConfig()
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("failed to load config", err)
 	}
 
-	cfg := config.GetConfig()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{Exporter: *traceExporter, Endpoint: *traceEndpoint}, "xlsxtosql")
+	if err != nil {
+		fatal("failed to set up tracing", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if *plan {
+		failed := false
+		for _, file := range cfg.ExcelFilePaths {
+			p, err := processXlsx.PlanExcelFile(ctx, *cfg, file)
+			if err != nil {
+				slog.Error("failed to plan", "file", file, "error", err)
+				failed = true
+			}
+			p.WriteText(os.Stdout)
+		}
+		if failed {
+			shutdownTracing(context.Background())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *exportPath != "" {
+		req := processXlsx.ExportRequest{Schema: *exportSchema, Queries: exportQueries, Sync: *exportSync}
+		if req.Schema == "" && len(cfg.ExcelFilePaths) > 0 {
+			req.Schema = cfg.SchemaFor(cfg.ExcelFilePaths[0])
+		}
+		if *exportTables != "" {
+			req.Tables = strings.Split(*exportTables, ",")
+		}
+		if err := export(ctx, *cfg, req, *exportPath); err != nil {
+			fatal("failed to export", err)
+		}
+		return
+	}
 
-	if *once {
+	if *retry {
+		failed := false
 		for _, file := range cfg.ExcelFilePaths {
-			err := processXlsx.ProcessExcelFile(*cfg, file)
+			result, err := processXlsx.RetryRejects(ctx, *cfg, file, *retrySheet)
 			if err != nil {
-				log.Printf("Error processing excel file: %v", err)
+				slog.Error("failed to retry rejects", "file", file, "error", err)
+				failed = true
+				continue
 			}
+			slog.Info("rejects retried", "file", file, "retried", result.Retried, "loaded", result.Loaded, "failed", result.Failed)
 		}
-		os.Exit(0)
-	} else {
-		for {
-			for _, file := range cfg.ExcelFilePaths {
-				err := processXlsx.ProcessExcelFile(*cfg, file)
-				if err != nil {
-					log.Printf("Error processing excel file: %v", err)
-				}
+		if failed {
+			shutdownTracing(context.Background())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				slog.Error("metrics listener failed", "addr", *metricsAddr, "error", err)
 			}
-			time.Sleep(time.Duration(cfg.IntervalSeconds) * time.Second)
+		}()
+	}
+
+	if !*once && cfg.IntervalSeconds <= 0 {
+		fatal("failed to load config", errors.New("interval_seconds must be positive unless -once is set"))
+	}
+	runner := processXlsx.NewRunner(*cfg, *once)
+	if *reportDir != "" {
+		runner.OnRunDone = func(run processXlsx.Run) {
+			path, err := run.WriteReport(*reportDir)
+			if err != nil {
+				slog.Error("failed to write load report", "error", err)
+				return
+			}
+			slog.Info("load report written", "path", path)
 		}
 	}
+	if err := runner.Run(ctx); err != nil {
+		slog.Info("shutdown signal received, exiting")
+	}
+}
+
+// export writes the workbook to a temporary file first, so that a failed
+// export does not leave a truncated one at path.
+func export(ctx context.Context, cfg config.Config, req processXlsx.ExportRequest, path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".export-*.xlsx")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	sheets, err := processXlsx.Export(ctx, cfg, req, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	for _, sheet := range sheets {
+		slog.Info("sheet exported", "sheet", sheet.Sheet, "source", sheet.Source, "rows", sheet.Rows)
+	}
+	slog.Info("workbook written", "path", path)
+	return nil
+}
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
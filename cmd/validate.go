@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"xlsxtoSQL/config"
+)
+
+// validate runs the validate subcommand: it checks a config file, including
+// the workbooks it names, and prints every problem with its line. It returns
+// the exit status, 1 when the config has problems.
+func validate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "config.yaml", "path to the config file")
+	once := fs.Bool("once", false, "the config is run once, so interval_seconds is not needed")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to open config file: %v\n", err)
+		return 2
+	}
+	_, problems := config.Check(data, config.CheckOptions{Once: *once, Files: true})
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]interface{}{"valid": len(problems) == 0, "problems": append(config.Problems{}, problems...)})
+	case "text":
+		for _, p := range problems {
+			location := *configPath
+			if p.Line > 0 {
+				location += fmt.Sprintf(":%d", p.Line)
+				if p.Column > 0 {
+					location += fmt.Sprintf(":%d", p.Column)
+				}
+			}
+			if p.Field != "" {
+				fmt.Fprintf(stdout, "%s: %s: %s\n", location, p.Field, p.Message)
+			} else {
+				fmt.Fprintf(stdout, "%s: %s\n", location, p.Message)
+			}
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(stdout, "%s: config is valid\n", *configPath)
+		}
+	default:
+		fmt.Fprintf(stderr, "unknown format %q\n", *format)
+		return 2
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -1,19 +1,104 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"strings"
 	"sync"
-
-	"gopkg.in/yaml.v2"
+	"time"
+	"xlsxtoSQL/fileref"
+	"xlsxtoSQL/postgres"
+	"xlsxtoSQL/rules"
 )
 
 type Config struct {
-	ExcelFilePaths    []string `yaml:"excel_file_paths"`
-	PostgresURLBaseDB string   `yaml:"postgres_url_base_db"`
-	IntervalSeconds   int      `yaml:"interval_seconds"`
-	IgnorantSheets    []string `yaml:"ignorant_sheets"`
+	ExcelFilePaths    []string `yaml:"excel_file_paths" json:"excel_file_paths"`
+	PostgresURLBaseDB string   `yaml:"postgres_url_base_db" json:"postgres_url_base_db,omitempty"`
+	// Connection names a connection of the API server's registry. It is
+	// resolved to PostgresURLBaseDB only when the job runs, so the password
+	// is never stored with the job.
+	Connection      string   `yaml:"connection" json:"connection,omitempty"`
+	IntervalSeconds int      `yaml:"interval_seconds" json:"interval_seconds"`
+	IgnorantSheets  []string `yaml:"ignorant_sheets" json:"ignorant_sheets"`
+	AllowedRoots    []string `yaml:"allowed_roots" json:"allowed_roots,omitempty"`
+	// Rules are checked on every row before it is loaded.
+	Rules []rules.Rule `yaml:"rules" json:"rules,omitempty"`
+	// LineageColumns are system columns added to every table to trace rows
+	// back to the workbook, see LineageColumnTypes.
+	LineageColumns []string `yaml:"lineage_columns" json:"lineage_columns,omitempty"`
+	// LoadMode is LoadModeUpsert (the default) or LoadModeHistory.
+	LoadMode string `yaml:"load_mode" json:"load_mode,omitempty"`
+	// NaturalKey names the columns identifying a row in history mode.
+	NaturalKey []string `yaml:"natural_key" json:"natural_key,omitempty"`
+	// FileWorkers is how many files are loaded at the same time, one by
+	// default. SheetWorkers is how many sheets of a file are loaded at the
+	// same time, at most and by default the size of the connection pool.
+	FileWorkers  int `yaml:"file_workers" json:"file_workers,omitempty"`
+	SheetWorkers int `yaml:"sheet_workers" json:"sheet_workers,omitempty"`
+	// Pool tunes the connection pool shared by the loads of a runner.
+	Pool postgres.PoolConfig `yaml:"pool" json:"pool,omitempty"`
+	// Lock keeps loads of other processes from writing the same tables at
+	// the same time.
+	Lock LockConfig `yaml:"lock" json:"lock,omitempty"`
+	// Schema names the schema a single workbook is loaded into instead of
+	// one derived from its path.
+	Schema string `yaml:"schema" json:"schema,omitempty"`
+}
+
+// LockConfig chooses the PostgreSQL advisory locks taken by a load.
+type LockConfig struct {
+	// Scope is LockScopeTable (the default), LockScopeFile or LockScopeNone.
+	Scope string `yaml:"scope" json:"scope,omitempty"`
+	// Policy is LockPolicyWait (the default) or LockPolicySkip.
+	Policy string `yaml:"policy" json:"policy,omitempty"`
+	// Timeout limits how long LockPolicyWait waits for a lock, without limit
+	// when zero.
+	Timeout time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+const (
+	// LoadModeUpsert overwrites rows by their row number.
+	LoadModeUpsert = "upsert"
+	// LoadModeHistory keeps every version of a row, keyed by NaturalKey, as
+	// a slowly changing dimension of type 2.
+	LoadModeHistory = "history"
+)
+
+const (
+	// LockScopeTable locks each table while its sheet is loaded.
+	LockScopeTable = "table"
+	// LockScopeFile locks the schema of a workbook while it is loaded.
+	LockScopeFile = "file"
+	// LockScopeNone takes no lock.
+	LockScopeNone = "none"
+
+	// LockPolicyWait waits for a lock held by another load.
+	LockPolicyWait = "wait"
+	// LockPolicySkip skips the sheet or file whose lock is held elsewhere.
+	LockPolicySkip = "skip"
+)
+
+// SchemaFor returns the schema the tables of file are loaded into: Schema
+// when set, the path of file otherwise, with spaces replaced by underscores.
+func (c Config) SchemaFor(file string) string {
+	if c.Schema != "" {
+		file = c.Schema
+	}
+	return strings.ReplaceAll(file, " ", "_")
+}
+
+// ResolveFile checks a workbook path against AllowedRoots and returns its
+// resolved location. Without allowed roots the path is returned unchanged.
+func (c Config) ResolveFile(file string) (string, error) {
+	if len(c.AllowedRoots) == 0 {
+		return file, nil
+	}
+	resolver, err := fileref.NewResolver(c.AllowedRoots)
+	if err != nil {
+		return "", err
+	}
+	return resolver.Resolve(file)
 }
 
 var (
@@ -22,31 +107,36 @@ var (
 	err    error
 )
 
+// Load reads and decodes a config file without touching the process-wide
+// config, so callers such as the API server can hold one Config per job.
+// Unknown keys and invalid settings are errors, see Check; whether the
+// workbooks exist and interval_seconds is set is left to the caller.
+func Load(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	cfg, problems := Check(data, CheckOptions{Once: true})
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid config file %s: %w", filename, problems)
+	}
+	return cfg, nil
+}
+
 func LoadConfig(filename string) error {
 	once.Do(func() {
-		var cfg Config
-		file, openErr := os.Open(filename)
-		if openErr != nil {
-			err = fmt.Errorf("failed to open config file: %w", openErr)
-			return
-		}
-		defer file.Close()
-
-		decoder := yaml.NewDecoder(file)
-		if decodeErr := decoder.Decode(&cfg); decodeErr != nil {
-			err = fmt.Errorf("failed to decode config file: %w", decodeErr)
-			return
-		}
-
-		config = &cfg
+		config, err = Load(filename)
 	})
 
 	return err
 }
 
-func GetConfig() *Config {
+// ErrNotLoaded is returned by GetConfig before LoadConfig succeeded.
+var ErrNotLoaded = errors.New("config not initialized, call LoadConfig first")
+
+func GetConfig() (*Config, error) {
 	if config == nil {
-		log.Fatal("Config not initialized. Call LoadConfig() first.")
+		return nil, ErrNotLoaded
 	}
-	return config
+	return config, nil
 }
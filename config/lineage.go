@@ -0,0 +1,12 @@
+package config
+
+// LineageColumnTypes lists the system columns a job may add to every table
+// with their PostgreSQL types.
+var LineageColumnTypes = map[string]string{
+	"_source_file":  "TEXT",
+	"_source_sheet": "TEXT",
+	"_source_row":   "INTEGER",
+	"_loaded_at":    "TIMESTAMPTZ",
+	"_load_id":      "TEXT",
+	"_row_hash":     "TEXT",
+}
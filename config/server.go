@@ -0,0 +1,100 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"xlsxtoSQL/auth"
+	"xlsxtoSQL/connections"
+	"xlsxtoSQL/tracing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ServerConfig configures the API server, as opposed to Config which
+// describes a single load job.
+type ServerConfig struct {
+	ListenAddr   string `yaml:"listen_addr"`
+	ControlDBURL string `yaml:"control_db_url"`
+	UploadDir    string `yaml:"upload_dir"`
+	MaxUploadMB  int64  `yaml:"max_upload_mb"`
+	// AllowedRoots are the directories workbooks may be loaded from. The
+	// upload directory is always allowed in addition to these.
+	AllowedRoots []string `yaml:"allowed_roots"`
+	// CORSOrigins lists the origins allowed to call the API from a browser.
+	// "*" allows any origin.
+	CORSOrigins []string   `yaml:"cors_origins"`
+	Auth        AuthConfig `yaml:"auth"`
+	// Connections are the databases jobs may load into, referenced by name.
+	Connections []connections.Connection `yaml:"connections"`
+	// SecretsFile maps connection names to passwords.
+	SecretsFile string `yaml:"secrets_file"`
+	// AllowRawPostgresURLs lets /api/start take a full postgres_url as before
+	// named connections existed.
+	AllowRawPostgresURLs bool `yaml:"allow_raw_postgres_urls"`
+	// LogDir holds one log file per instance; LogBufferSize entries of each
+	// are kept in memory for /api/logs.
+	LogDir        string `yaml:"log_dir"`
+	LogBufferSize int    `yaml:"log_buffer_size"`
+	// LogFormat is "text" or "json"; LogLevel is the minimum level written
+	// to the process output, job logs always keep every level.
+	LogFormat string `yaml:"log_format"`
+	LogLevel  string `yaml:"log_level"`
+	// ReportDir keeps the JSON load report of every run, one directory per
+	// instance. Empty disables reports.
+	ReportDir string `yaml:"report_dir"`
+	// Tracing exports OpenTelemetry spans of requests, loads and SQL.
+	Tracing tracing.Config `yaml:"tracing"`
+}
+
+// AuthConfig selects the authenticators of the API server. With none of them
+// configured every request is treated as coming from an admin.
+type AuthConfig struct {
+	Tokens    []auth.Token   `yaml:"tokens"`
+	UsersFile string         `yaml:"users_file"`
+	JWT       auth.JWTConfig `yaml:"jwt"`
+}
+
+// Enabled reports whether any authenticator is configured.
+func (c AuthConfig) Enabled() bool {
+	return len(c.Tokens) > 0 || c.UsersFile != "" || c.JWT.JWKSFile != ""
+}
+
+// FileRoots returns every directory the server may load workbooks from.
+func (c *ServerConfig) FileRoots() []string {
+	return append(append([]string(nil), c.AllowedRoots...), c.UploadDir)
+}
+
+// LoadServerConfig reads the API server config. A missing file is not an
+// error: the defaults are used so the server can run without any setup.
+func LoadServerConfig(filename string) (*ServerConfig, error) {
+	cfg := ServerConfig{
+		ListenAddr:    ":8080",
+		UploadDir:     "/app/uploads",
+		MaxUploadMB:   50,
+		CORSOrigins:   []string{"http://localhost:3000"},
+		LogDir:        "/app/logs",
+		LogBufferSize: 1000,
+		ReportDir:     "/app/reports",
+	}
+	defaultRoots := []string{"/app/data"}
+
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		cfg.AllowedRoots = defaultRoots
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open server config file: %w", err)
+	}
+	defer file.Close()
+
+	if err := yaml.NewDecoder(file).Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to decode server config file: %w", err)
+	}
+	if cfg.AllowedRoots == nil {
+		cfg.AllowedRoots = defaultRoots
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,350 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"xlsxtoSQL/fileref"
+	"xlsxtoSQL/postgres"
+	"xlsxtoSQL/rules"
+
+	"github.com/xuri/excelize/v2"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Problem is one thing wrong with a config. Field is the YAML path of the
+// setting, such as lock.scope or excel_file_paths[1]. Line and Column locate
+// it in the YAML source, when the config was read from one.
+type Problem struct {
+	Field   string `json:"field,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "line %d", p.Line)
+		if p.Column > 0 {
+			fmt.Fprintf(&b, ", column %d", p.Column)
+		}
+		b.WriteString(": ")
+	}
+	if p.Field != "" {
+		b.WriteString(p.Field + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// Problems is the error of a config that has problems, listing all of them.
+type Problems []Problem
+
+func (ps Problems) Error() string {
+	messages := make([]string, len(ps))
+	for i, p := range ps {
+		messages[i] = p.String()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// CheckOptions chooses what Check verifies beyond the config itself.
+type CheckOptions struct {
+	// Once is set when the config is loaded a single time, which does not
+	// need interval_seconds.
+	Once bool
+	// Files opens the workbooks to check that they exist and have the sheets
+	// the config names.
+	Files bool
+	// Resolve, if set, resolves the workbook paths in place of AllowedRoots,
+	// such as with the roots of the API server.
+	Resolve func(name string) (string, error)
+	// Connection, if set, checks the connection the config names.
+	Connection func(name string) error
+}
+
+// Validate checks the parts of a job config that can be wrong independently
+// of the files and databases it names. Its error is Problems.
+func (c Config) Validate() error {
+	if problems := c.problems(); len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func (c Config) problems() Problems {
+	var ps Problems
+	add := func(field, format string, args ...interface{}) {
+		ps = append(ps, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for i, rule := range c.Rules {
+		if _, err := rules.Compile([]rules.Rule{rule}); err != nil {
+			add(fmt.Sprintf("rules[%d]", i), "%v", err)
+		}
+	}
+	if c.IntervalSeconds < 0 {
+		add("interval_seconds", "cannot be negative")
+	}
+	if c.Schema != "" && len(c.ExcelFilePaths) > 1 {
+		add("schema", "can only be set for a single workbook")
+	}
+	seen := map[string]bool{}
+	for i, column := range c.LineageColumns {
+		field := fmt.Sprintf("lineage_columns[%d]", i)
+		if _, ok := LineageColumnTypes[column]; !ok {
+			add(field, "unknown lineage column %q", column)
+		} else if seen[column] {
+			add(field, "lineage column %q is listed twice", column)
+		}
+		seen[column] = true
+	}
+
+	switch c.LoadMode {
+	case "", LoadModeUpsert:
+		if len(c.NaturalKey) > 0 {
+			add("natural_key", "needs load_mode %q", LoadModeHistory)
+		}
+	case LoadModeHistory:
+		if len(c.NaturalKey) == 0 {
+			add("load_mode", "%q needs a natural_key", LoadModeHistory)
+		}
+	default:
+		add("load_mode", "unknown load_mode %q", c.LoadMode)
+	}
+	seen = map[string]bool{}
+	for i, column := range c.NaturalKey {
+		field := fmt.Sprintf("natural_key[%d]", i)
+		if column == "" {
+			add(field, "empty column name")
+		} else if seen[column] {
+			add(field, "column %q is listed twice", column)
+		}
+		seen[column] = true
+	}
+
+	if c.FileWorkers < 0 {
+		add("file_workers", "cannot be negative")
+	}
+	if c.SheetWorkers < 0 {
+		add("sheet_workers", "cannot be negative")
+	}
+
+	switch c.Lock.Scope {
+	case "", LockScopeTable, LockScopeFile, LockScopeNone:
+	default:
+		add("lock.scope", "unknown lock scope %q", c.Lock.Scope)
+	}
+	switch c.Lock.Policy {
+	case "", LockPolicyWait, LockPolicySkip:
+	default:
+		add("lock.policy", "unknown lock policy %q", c.Lock.Policy)
+	}
+	if c.Lock.Timeout < 0 {
+		add("lock.timeout", "cannot be negative")
+	}
+
+	if c.Pool.MaxConns < 0 {
+		add("pool.max_conns", "cannot be negative")
+	}
+	if c.Pool.MinConns < 0 {
+		add("pool.min_conns", "cannot be negative")
+	}
+	if c.Pool.MaxConns > 0 && c.Pool.MinConns > c.Pool.MaxConns {
+		add("pool.min_conns", "%d exceeds max_conns %d", c.Pool.MinConns, c.Pool.MaxConns)
+	}
+	if c.Pool.RetryAttempts < 0 {
+		add("pool.retry_attempts", "cannot be negative")
+	}
+	return ps
+}
+
+// Check decodes a YAML config strictly and reports every problem found in
+// it: unknown keys, values of the wrong type, invalid settings and, as
+// chosen by opts, what it names. Problems carry their line in data when it
+// can be told. The config is returned unless data is not valid YAML.
+func Check(data []byte, opts CheckOptions) (*Config, Problems) {
+	var cfg Config
+	var ps Problems
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			// A syntax error leaves nothing else to check.
+			return nil, Problems{decodeProblem(err.Error())}
+		}
+		for _, message := range typeErr.Errors {
+			ps = append(ps, decodeProblem(message))
+		}
+	}
+	ps = append(ps, cfg.problems()...)
+	ps = append(ps, cfg.runProblems(opts)...)
+	locate(data, ps)
+	// Problems without a line come last.
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[j].Line == 0 && ps[i].Line > 0 || ps[i].Line > 0 && ps[i].Line < ps[j].Line
+	})
+	return &cfg, ps
+}
+
+var (
+	decodeLine   = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	unknownField = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// decodeProblem turns a message of the YAML decoder into a Problem.
+func decodeProblem(message string) Problem {
+	m := decodeLine.FindStringSubmatch(message)
+	if m == nil {
+		return Problem{Message: message}
+	}
+	line, _ := strconv.Atoi(m[1])
+	message = m[2]
+	if f := unknownField.FindStringSubmatch(message); f != nil {
+		message = fmt.Sprintf("unknown key %q", f[1])
+	}
+	return Problem{Line: line, Message: message}
+}
+
+// runProblems checks what a config needs to run and, with opts.Files, the
+// workbooks it names.
+func (c Config) runProblems(opts CheckOptions) Problems {
+	var ps Problems
+	add := func(field, format string, args ...interface{}) {
+		ps = append(ps, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !opts.Once && c.IntervalSeconds <= 0 {
+		add("interval_seconds", "must be positive unless the config is run once")
+	}
+	switch {
+	case c.PostgresURLBaseDB != "":
+		if err := postgres.CheckURL(c.PostgresURLBaseDB); err != nil {
+			add("postgres_url_base_db", "%v", err)
+		}
+	case c.Connection == "":
+		add("postgres_url_base_db", "is required")
+	}
+	if c.Connection != "" && opts.Connection != nil {
+		if err := opts.Connection(c.Connection); err != nil {
+			add("connection", "%v", err)
+		}
+	}
+	if len(c.ExcelFilePaths) == 0 {
+		add("excel_file_paths", "no workbook to load")
+	}
+	if !opts.Files {
+		return ps
+	}
+
+	resolve := opts.Resolve
+	if resolve == nil {
+		resolve = func(name string) (string, error) { return name, nil }
+		if len(c.AllowedRoots) > 0 {
+			resolver, err := fileref.NewResolver(c.AllowedRoots)
+			if err != nil {
+				add("allowed_roots", "%v", err)
+				return ps
+			}
+			resolve = resolver.Resolve
+		}
+	}
+
+	// Sheets are only known missing when every workbook could be read.
+	sheets := map[string]bool{}
+	complete := true
+	for i, file := range c.ExcelFilePaths {
+		field := fmt.Sprintf("excel_file_paths[%d]", i)
+		path, err := resolve(file)
+		if err == nil {
+			_, err = os.Stat(path)
+		}
+		if err != nil {
+			add(field, "%v", err)
+			complete = false
+			continue
+		}
+		f, err := excelize.OpenFile(path)
+		if err != nil {
+			add(field, "cannot open workbook: %v", err)
+			complete = false
+			continue
+		}
+		for _, sheet := range f.GetSheetList() {
+			sheets[sheet] = true
+		}
+		f.Close()
+	}
+	if !complete {
+		return ps
+	}
+	for i, sheet := range c.IgnorantSheets {
+		if !sheets[sheet] {
+			add(fmt.Sprintf("ignorant_sheets[%d]", i), "sheet %q is in none of the workbooks", sheet)
+		}
+	}
+	for i, rule := range c.Rules {
+		if rule.Sheet != "" && !sheets[rule.Sheet] {
+			add(fmt.Sprintf("rules[%d].sheet", i), "sheet %q is in none of the workbooks", rule.Sheet)
+		}
+	}
+	return ps
+}
+
+// locate sets the line and column of the problems that name a field, from
+// the field or, when it is not in data, from the closest parent that is.
+func locate(data []byte, ps Problems) {
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return
+	}
+	positions := map[string]*yaml3.Node{}
+	var walk func(path string, node *yaml3.Node)
+	walk = func(path string, node *yaml3.Node) {
+		positions[path] = node
+		switch node.Kind {
+		case yaml3.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i].Value
+				if path != "" {
+					key = path + "." + key
+				}
+				walk(key, node.Content[i+1])
+				// A key is shown where it is written, its value may start
+				// on the next line.
+				positions[key] = node.Content[i]
+			}
+		case yaml3.SequenceNode:
+			for i, item := range node.Content {
+				walk(fmt.Sprintf("%s[%d]", path, i), item)
+			}
+		}
+	}
+	walk("", doc.Content[0])
+
+	for i := range ps {
+		if ps[i].Line > 0 || ps[i].Field == "" {
+			continue
+		}
+		for path := ps[i].Field; path != ""; path = parentPath(path) {
+			if node, ok := positions[path]; ok {
+				ps[i].Line, ps[i].Column = node.Line, node.Column
+				break
+			}
+		}
+	}
+}
+
+// parentPath strips the last key or index of a field path.
+func parentPath(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
@@ -0,0 +1,162 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v2"
+)
+
+var ErrUnknownConnection = errors.New("unknown connection")
+
+// Connection is a named PostgreSQL target. The password is never part of the
+// definition itself: it comes from the environment variable PasswordEnv or
+// from the secrets file entry with the connection's name.
+type Connection struct {
+	Name        string            `yaml:"name" json:"name"`
+	Host        string            `yaml:"host" json:"host"`
+	Port        int               `yaml:"port" json:"port"`
+	Database    string            `yaml:"database" json:"database"`
+	User        string            `yaml:"user" json:"user"`
+	PasswordEnv string            `yaml:"password_env" json:"-"`
+	SSLMode     string            `yaml:"sslmode" json:"sslmode,omitempty"`
+	Params      map[string]string `yaml:"params" json:"params,omitempty"`
+}
+
+// Registry resolves connection names to connection URLs.
+type Registry struct {
+	conns   map[string]Connection
+	secrets map[string]string
+}
+
+// NewRegistry builds a registry from connection definitions and an optional
+// YAML secrets file mapping connection names to passwords.
+func NewRegistry(conns []Connection, secretsFile string) (*Registry, error) {
+	r := &Registry{conns: make(map[string]Connection, len(conns)), secrets: map[string]string{}}
+	for _, c := range conns {
+		if c.Name == "" {
+			return nil, errors.New("connection without a name")
+		}
+		if _, exists := r.conns[c.Name]; exists {
+			return nil, fmt.Errorf("connection %q is defined twice", c.Name)
+		}
+		if c.Host == "" || c.Database == "" {
+			return nil, fmt.Errorf("connection %q needs host and database", c.Name)
+		}
+		if c.Port == 0 {
+			c.Port = 5432
+		}
+		r.conns[c.Name] = c
+	}
+
+	if secretsFile != "" {
+		data, err := os.ReadFile(secretsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &r.secrets); err != nil {
+			return nil, fmt.Errorf("failed to decode secrets file: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// List returns the connection definitions sorted by name. They never carry
+// passwords.
+func (r *Registry) List() []Connection {
+	list := make([]Connection, 0, len(r.conns))
+	for _, c := range r.conns {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (r *Registry) Get(name string) (Connection, error) {
+	c, ok := r.conns[name]
+	if !ok {
+		return Connection{}, fmt.Errorf("%w: %q", ErrUnknownConnection, name)
+	}
+	return c, nil
+}
+
+// URL builds the connection URL, password included. It must only be handed
+// to the database driver and never logged or returned to clients.
+func (r *Registry) URL(name string) (string, error) {
+	c, err := r.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	password := r.secrets[name]
+	if c.PasswordEnv != "" {
+		if v, ok := os.LookupEnv(c.PasswordEnv); ok {
+			password = v
+		}
+	}
+
+	u := url.URL{
+		Scheme: "postgresql",
+		Host:   c.Host + ":" + strconv.Itoa(c.Port),
+		Path:   "/" + c.Database,
+	}
+	if password != "" {
+		u.User = url.UserPassword(c.User, password)
+	} else if c.User != "" {
+		u.User = url.User(c.User)
+	}
+	query := url.Values{}
+	for k, v := range c.Params {
+		query.Set(k, v)
+	}
+	if c.SSLMode != "" {
+		query.Set("sslmode", c.SSLMode)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Test opens a single connection and pings the server. The returned error
+// never contains the password.
+func (r *Registry) Test(ctx context.Context, name string) (time.Duration, error) {
+	connURL, err := r.URL(name)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	conn, err := pgx.Connect(ctx, connURL)
+	if err != nil {
+		return 0, fmt.Errorf("connection %q failed: %s", name, r.redact(name, err.Error()))
+	}
+	defer conn.Close(context.Background())
+	if err := conn.Ping(ctx); err != nil {
+		return 0, fmt.Errorf("connection %q failed: %s", name, r.redact(name, err.Error()))
+	}
+	return time.Since(start), nil
+}
+
+// redact removes the password of a connection from a message as a last line
+// of defence; pgx already masks passwords in its own errors.
+func (r *Registry) redact(name, msg string) string {
+	connURL, err := r.URL(name)
+	if err != nil {
+		return msg
+	}
+	u, err := url.Parse(connURL)
+	if err != nil || u.User == nil {
+		return msg
+	}
+	if password, ok := u.User.Password(); ok && password != "" {
+		return strings.ReplaceAll(msg, password, "xxxxx")
+	}
+	return msg
+}
@@ -0,0 +1,118 @@
+package fileref
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalidPath is returned for names that are not usable paths at all,
+	// such as empty names or names containing NUL bytes.
+	ErrInvalidPath = errors.New("invalid path")
+	// ErrForbidden is returned for paths that resolve outside every allowed
+	// root, either lexically or by following a symlink.
+	ErrForbidden = errors.New("path is outside the allowed roots")
+	// ErrNotFound is returned when the path is allowed but does not exist.
+	ErrNotFound = errors.New("file not found")
+)
+
+// PathError describes why a file reference was rejected. Err is one of
+// ErrInvalidPath, ErrForbidden or ErrNotFound.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// Resolver turns user supplied file references into absolute paths that are
+// guaranteed to stay inside one of its roots after symlinks are followed.
+type Resolver struct {
+	roots []string
+}
+
+// NewResolver creates a resolver for the given roots. Roots that do not exist
+// are kept as given so that they can be created later.
+func NewResolver(roots []string) (*Resolver, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("at least one allowed root is required")
+	}
+	resolved := make([]string, 0, len(roots))
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed root %s: %w", root, err)
+		}
+		if real, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = real
+		}
+		resolved = append(resolved, abs)
+	}
+	return &Resolver{roots: resolved}, nil
+}
+
+// Roots returns the absolute roots of the resolver.
+func (r *Resolver) Roots() []string {
+	return append([]string(nil), r.roots...)
+}
+
+// Resolve returns the absolute, symlink free path of name. Relative names are
+// looked up in each root in order; absolute names must lie inside a root.
+func (r *Resolver) Resolve(name string) (string, error) {
+	if strings.TrimSpace(name) == "" || strings.ContainsRune(name, 0) {
+		return "", &PathError{Path: name, Err: ErrInvalidPath}
+	}
+
+	var candidates []string
+	if filepath.IsAbs(name) {
+		candidates = []string{filepath.Clean(name)}
+	} else {
+		if !filepath.IsLocal(name) {
+			return "", &PathError{Path: name, Err: ErrForbidden}
+		}
+		for _, root := range r.roots {
+			candidates = append(candidates, filepath.Join(root, name))
+		}
+	}
+
+	found := false
+	for _, candidate := range candidates {
+		real, err := filepath.EvalSymlinks(candidate)
+		if err != nil {
+			continue
+		}
+		found = true
+		if r.contains(real) {
+			return real, nil
+		}
+	}
+	if found {
+		return "", &PathError{Path: name, Err: ErrForbidden}
+	}
+
+	// Nothing exists yet, so only the lexical check is possible.
+	for _, candidate := range candidates {
+		if r.contains(candidate) {
+			return "", &PathError{Path: name, Err: ErrNotFound}
+		}
+	}
+	return "", &PathError{Path: name, Err: ErrForbidden}
+}
+
+func (r *Resolver) contains(path string) bool {
+	for _, root := range r.roots {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && filepath.IsLocal(rel) {
+			return true
+		}
+	}
+	return false
+}
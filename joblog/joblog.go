@@ -0,0 +1,241 @@
+package joblog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is one captured log record.
+type Entry struct {
+	Seq     int64                  `json:"seq"`
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"msg"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// Buffer keeps the latest entries of a job in memory, appends every entry to
+// a JSON lines file and fans new entries out to subscribers.
+type Buffer struct {
+	mu          sync.Mutex
+	entries     []Entry
+	size        int
+	seq         int64
+	file        *os.File
+	subscribers map[chan Entry]struct{}
+}
+
+// NewBuffer creates a buffer holding up to size entries. If path is not empty
+// entries are appended to it, and the tail of an existing file is loaded so
+// that logs survive a restart.
+func NewBuffer(size int, path string) (*Buffer, error) {
+	b := &Buffer{size: size, subscribers: make(map[chan Entry]struct{})}
+	if path == "" {
+		return b, nil
+	}
+
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var e Entry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				b.push(e)
+				b.seq = e.Seq
+			}
+		}
+		existing.Close()
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	b.file = file
+	return b, nil
+}
+
+func (b *Buffer) push(e Entry) {
+	b.entries = append(b.entries, e)
+	if len(b.entries) > b.size {
+		b.entries = b.entries[len(b.entries)-b.size:]
+	}
+}
+
+func (b *Buffer) add(e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e.Seq = b.seq
+	b.push(e)
+	if b.file != nil {
+		if line, err := json.Marshal(e); err == nil {
+			b.file.Write(append(line, '\n'))
+		}
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// A subscriber that does not keep up misses entries rather than
+			// blocking the job.
+		}
+	}
+}
+
+// Tail returns up to n of the latest entries at or above minLevel.
+func (b *Buffer) Tail(n int, minLevel slog.Level) []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := []Entry{}
+	for i := len(b.entries) - 1; i >= 0 && len(result) < n; i-- {
+		if LevelOf(b.entries[i]) >= minLevel {
+			result = append(result, b.entries[i])
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// Subscribe returns a channel receiving every new entry and a function that
+// cancels the subscription.
+func (b *Buffer) Subscribe() (<-chan Entry, func()) {
+	ch := make(chan Entry, 256)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Close closes the log file. The buffer stays readable.
+func (b *Buffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	b.file = nil
+	return err
+}
+
+// LevelOf parses the level of an entry.
+func LevelOf(e Entry) slog.Level {
+	level, _ := ParseLevel(e.Level)
+	return level
+}
+
+// ParseLevel accepts the slog level names in any case.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(strings.TrimSpace(name)))); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// Handler returns an slog.Handler that records into b and passes every record
+// on to next, so job logs still reach the process output.
+func (b *Buffer) Handler(next slog.Handler) slog.Handler {
+	return &handler{buffer: b, next: next}
+}
+
+type handler struct {
+	buffer *Buffer
+	next   slog.Handler
+	attrs  []slog.Attr
+	group  string
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	// Everything is captured; the next handler applies its own level when
+	// the record is passed on.
+	return true
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make(map[string]interface{}, len(h.attrs)+r.NumAttrs())
+	for _, a := range h.attrs {
+		addAttr(attrs, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(attrs, h.group, a)
+		return true
+	})
+	h.buffer.add(Entry{Time: r.Time, Level: r.Level.String(), Message: r.Message, Attrs: attrs})
+
+	if h.next != nil && h.next.Enabled(ctx, r.Level) {
+		return h.next.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + "." + a.Key
+		}
+		clone.attrs = append(clone.attrs, a)
+	}
+	if h.next != nil {
+		clone.next = h.next.WithAttrs(attrs)
+	}
+	return &clone
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.group = name
+	if h.group != "" {
+		clone.group = h.group + "." + name
+	}
+	if h.next != nil {
+		clone.next = h.next.WithGroup(name)
+	}
+	return &clone
+}
+
+func addAttr(attrs map[string]interface{}, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	key := a.Key
+	if prefix != "" {
+		key = prefix + "." + key
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			addAttr(attrs, key, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	switch a.Value.Kind() {
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			attrs[key] = err.Error()
+			return
+		}
+		attrs[key] = a.Value.Any()
+	default:
+		attrs[key] = a.Value.Any()
+	}
+}
@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"xlsxtoSQL/joblog"
+)
+
+// New builds a logger writing to w in format "json" or "text" (the default)
+// at the given minimum level name.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	minLevel := slog.LevelInfo
+	if level != "" {
+		var err error
+		if minLevel, err = joblog.ParseLevel(level); err != nil {
+			return nil, err
+		}
+	}
+	opts := &slog.HandlerOptions{Level: minLevel}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use json or text", format)
+	}
+}
+
+// Setup makes the logger of New the process default. Output of the standard
+// log package goes through it as well.
+func Setup(w io.Writer, format, level string) error {
+	logger, err := New(w, format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric of the loader and the API server.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Rows counts the rows written to or rejected from a table, by result:
+	// inserted, updated or rejected.
+	Rows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xlsxtosql_rows_total",
+		Help: "Rows loaded into a table, by result.",
+	}, []string{"schema", "table", "result"})
+
+	LoadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "xlsxtosql_load_duration_seconds",
+		Help:    "Duration of loading a workbook, by status.",
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"file", "status"})
+
+	LastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "xlsxtosql_last_success_timestamp_seconds",
+		Help: "Unix time of the last load of a workbook that finished without errors.",
+	}, []string{"file"})
+
+	TypeAlterations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xlsxtosql_column_type_alterations_total",
+		Help: "Column types changed because a value did not fit.",
+	}, []string{"schema", "table"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Rows, LoadDuration, LastSuccess, TypeAlterations,
+		pools,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// AddRows counts the outcome of loading a sheet into schema.table.
+func AddRows(schema, table string, inserted, updated, rejected int) {
+	Rows.WithLabelValues(schema, table, "inserted").Add(float64(inserted))
+	Rows.WithLabelValues(schema, table, "updated").Add(float64(updated))
+	Rows.WithLabelValues(schema, table, "rejected").Add(float64(rejected))
+}
+
+var pools = &poolCollector{pools: map[*pgxpool.Pool]string{}}
+
+// TrackPool exports the statistics of a connection pool under name until the
+// returned function is called. Pools sharing a name are summed.
+func TrackPool(name string, pool *pgxpool.Pool) func() {
+	pools.mu.Lock()
+	pools.pools[pool] = name
+	pools.mu.Unlock()
+	return func() {
+		pools.mu.Lock()
+		delete(pools.pools, pool)
+		pools.mu.Unlock()
+	}
+}
+
+type poolCollector struct {
+	mu    sync.Mutex
+	pools map[*pgxpool.Pool]string
+}
+
+var (
+	poolAcquired = prometheus.NewDesc("xlsxtosql_pool_acquired_connections",
+		"Connections currently in use.", []string{"pool"}, nil)
+	poolIdle = prometheus.NewDesc("xlsxtosql_pool_idle_connections",
+		"Idle connections.", []string{"pool"}, nil)
+	poolTotal = prometheus.NewDesc("xlsxtosql_pool_total_connections",
+		"Open connections.", []string{"pool"}, nil)
+	poolMax = prometheus.NewDesc("xlsxtosql_pool_max_connections",
+		"Maximum size of the pools.", []string{"pool"}, nil)
+	poolAcquires = prometheus.NewDesc("xlsxtosql_pool_acquires_total",
+		"Successful connection acquisitions.", []string{"pool"}, nil)
+	poolAcquireWait = prometheus.NewDesc("xlsxtosql_pool_acquire_wait_seconds_total",
+		"Time spent waiting for a connection.", []string{"pool"}, nil)
+	poolEmptyAcquires = prometheus.NewDesc("xlsxtosql_pool_empty_acquires_total",
+		"Acquisitions that had to wait for a connection.", []string{"pool"}, nil)
+)
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{poolAcquired, poolIdle, poolTotal, poolMax, poolAcquires, poolAcquireWait, poolEmptyAcquires} {
+		ch <- d
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	type sums struct {
+		acquired, idle, total, max, acquires, wait, empty float64
+	}
+	c.mu.Lock()
+	byName := map[string]*sums{}
+	for pool, name := range c.pools {
+		s := byName[name]
+		if s == nil {
+			s = &sums{}
+			byName[name] = s
+		}
+		stat := pool.Stat()
+		s.acquired += float64(stat.AcquiredConns())
+		s.idle += float64(stat.IdleConns())
+		s.total += float64(stat.TotalConns())
+		s.max += float64(stat.MaxConns())
+		s.acquires += float64(stat.AcquireCount())
+		s.wait += stat.AcquireDuration().Seconds()
+		s.empty += float64(stat.EmptyAcquireCount())
+	}
+	c.mu.Unlock()
+
+	for name, s := range byName {
+		ch <- prometheus.MustNewConstMetric(poolAcquired, prometheus.GaugeValue, s.acquired, name)
+		ch <- prometheus.MustNewConstMetric(poolIdle, prometheus.GaugeValue, s.idle, name)
+		ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, s.total, name)
+		ch <- prometheus.MustNewConstMetric(poolMax, prometheus.GaugeValue, s.max, name)
+		ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, s.acquires, name)
+		ch <- prometheus.MustNewConstMetric(poolAcquireWait, prometheus.CounterValue, s.wait, name)
+		ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, s.empty, name)
+	}
+}
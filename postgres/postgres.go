@@ -2,33 +2,188 @@ package postgres
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
 	"sync"
-	cfg "xlsxtoSQL/config"
+	"time"
+	"xlsxtoSQL/metrics"
+	"xlsxtoSQL/tracing"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// PoolConfig tunes the connection pool and the retries of transient errors.
+// Zero values keep the defaults of pgx, or of this package for the retries.
+type PoolConfig struct {
+	MaxConns          int32         `yaml:"max_conns" json:"max_conns,omitempty"`
+	MinConns          int32         `yaml:"min_conns" json:"min_conns,omitempty"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime" json:"max_conn_lifetime,omitempty"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time" json:"max_conn_idle_time,omitempty"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period" json:"health_check_period,omitempty"`
+	ConnectTimeout    time.Duration `yaml:"connect_timeout" json:"connect_timeout,omitempty"`
+	// ApplicationName is reported in pg_stat_activity, "xlsxtosql" unless
+	// the URL sets one.
+	ApplicationName string `yaml:"application_name" json:"application_name,omitempty"`
+	// RetryAttempts is how many times an operation failing with a transient
+	// connection error is tried in all. The wait starts at RetryBackoff and
+	// doubles up to RetryMaxBackoff.
+	RetryAttempts   int           `yaml:"retry_attempts" json:"retry_attempts,omitempty"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff" json:"retry_backoff,omitempty"`
+	RetryMaxBackoff time.Duration `yaml:"retry_max_backoff" json:"retry_max_backoff,omitempty"`
+}
+
+const (
+	defaultApplicationName = "xlsxtosql"
+	defaultRetryAttempts   = 5
+	defaultRetryBackoff    = 500 * time.Millisecond
+	defaultRetryMaxBackoff = 10 * time.Second
+)
+
 type PgStorage struct {
-	Mu   sync.RWMutex
-	Pool *pgxpool.Pool
+	Mu      sync.RWMutex
+	Pool    *pgxpool.Pool
+	untrack func()
+	retry   PoolConfig
 }
 
-func Init(ctx context.Context) *PgStorage {
-	cfg := cfg.GetConfig()
+// Init opens a connection pool to postgresURL with the default settings. It
+// returns an error instead of exiting so that it can be used by long-lived
+// processes such as the API server.
+func Init(ctx context.Context, postgresURL string) (*PgStorage, error) {
+	return Open(ctx, postgresURL, PoolConfig{})
+}
 
-	var err error
-	pool, err := pgxpool.New(ctx, cfg.PostgresURLBaseDB)
+// CheckURL reports whether postgresURL can be parsed, without connecting.
+func CheckURL(postgresURL string) error {
+	_, err := pgxpool.ParseConfig(postgresURL)
+	return err
+}
+
+// Open opens a connection pool to postgresURL configured by cfg and checks
+// that the database is reachable, retrying transient errors.
+func Open(ctx context.Context, postgresURL string, cfg PoolConfig) (*PgStorage, error) {
+	poolConfig, err := pgxpool.ParseConfig(postgresURL)
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v", err)
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
 	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+	if cfg.ConnectTimeout > 0 {
+		poolConfig.ConnConfig.ConnectTimeout = cfg.ConnectTimeout
+	}
+	switch {
+	case cfg.ApplicationName != "":
+		poolConfig.ConnConfig.RuntimeParams["application_name"] = cfg.ApplicationName
+	case poolConfig.ConnConfig.RuntimeParams["application_name"] == "":
+		poolConfig.ConnConfig.RuntimeParams["application_name"] = defaultApplicationName
+	}
+	poolConfig.ConnConfig.Tracer = tracing.QueryTracer{}
 
-	log.Println("Postgres сonnect success")
-	return &PgStorage{Pool: pool}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	p := &PgStorage{Pool: pool, retry: cfg}
+	if err := p.Retry(ctx, "ping", func(ctx context.Context) error { return pool.Ping(ctx) }); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	p.untrack = metrics.TrackPool("loader", pool)
+
+	slog.Debug("connected to PostgreSQL", "host", poolConfig.ConnConfig.Host, "database", poolConfig.ConnConfig.Database,
+		"max_conns", poolConfig.MaxConns)
+	return p, nil
 }
+
 func (p *PgStorage) Close() {
 	if p.Pool != nil {
+		if p.untrack != nil {
+			p.untrack()
+		}
 		p.Pool.Close()
-		log.Println("Database connect closed")
+		slog.Debug("PostgreSQL connection pool closed")
+	}
+}
+
+// Begin starts a transaction, retrying transient connection errors.
+func (p *PgStorage) Begin(ctx context.Context) (pgx.Tx, error) {
+	var tx pgx.Tx
+	err := p.Retry(ctx, "begin", func(ctx context.Context) error {
+		var err error
+		tx, err = p.Pool.Begin(ctx)
+		return err
+	})
+	return tx, err
+}
+
+// Retry calls fn until it succeeds or fails with an error that is not
+// transient, at most RetryAttempts times, waiting with exponential backoff
+// in between. fn must be safe to repeat: it should fail before anything was
+// written, such as when acquiring a connection.
+func (p *PgStorage) Retry(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	attempts, backoff, maxBackoff := p.retry.RetryAttempts, p.retry.RetryBackoff, p.retry.RetryMaxBackoff
+	if attempts <= 0 {
+		attempts = defaultRetryAttempts
+	}
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= attempts || !IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		slog.Warn("transient database error, retrying", "op", op, "attempt", attempt, "wait", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// IsTransient reports whether err is a connection error that may go away by
+// itself, such as a restarting server or one out of connection slots.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "57P01", "57P02", "57P03", "53300":
+			// admin_shutdown, crash_shutdown, cannot_connect_now, too_many_connections
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+	if pgconn.SafeToRetry(err) {
+		return true
 	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) || errors.As(err, &netErr)
 }
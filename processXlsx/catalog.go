@@ -0,0 +1,93 @@
+package processXlsx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lib/pq"
+)
+
+// loadsTable records every load of a workbook in its schema, so that rows
+// carrying a _load_id can be traced back to the run and the cell ranges
+// they came from.
+const loadsTable = "_loads"
+
+// reservedSheets are the names of the system tables of a schema: sheets with
+// these names are never loaded.
+var reservedSheets = []string{rejectsTable, loadsTable}
+
+// recordLoad stores the outcome of loading a workbook. Errors are logged: a
+// catalog that cannot be written must not fail a load that succeeded.
+func recordLoad(ctx context.Context, pool *pgxpool.Pool, schema string, result *Result, loadErr error) {
+	status := "finished"
+	if loadErr != nil {
+		status = "failed"
+	}
+	for _, sheet := range result.Sheets {
+		if sheet.Error != "" {
+			status = "failed"
+		}
+	}
+	if ctx.Err() != nil {
+		status = "stopped"
+	}
+	// The catalog is written even when the load was cancelled.
+	ctx = context.WithoutCancel(ctx)
+
+	errText := ""
+	if loadErr != nil {
+		errText = loadErr.Error()
+	}
+
+	type sheetEntry struct {
+		Sheet    string `json:"sheet"`
+		Range    string `json:"range,omitempty"`
+		Rows     int    `json:"rows"`
+		Inserted int    `json:"inserted"`
+		Updated  int    `json:"updated"`
+		Failed   int    `json:"failed"`
+		Error    string `json:"error,omitempty"`
+		Skipped  string `json:"skipped,omitempty"`
+	}
+	sheets := make([]sheetEntry, 0, len(result.Sheets))
+	for _, s := range result.Sheets {
+		sheets = append(sheets, sheetEntry{s.Sheet, s.Range, s.Rows, s.Inserted, s.Updated, s.Failed, s.Error, s.Skipped})
+	}
+	sheetsJSON, err := json.Marshal(sheets)
+	if err != nil {
+		logger(ctx).Error("failed to encode load catalog entry", "error", err)
+		return
+	}
+
+	table := pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(loadsTable)
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+load_id TEXT PRIMARY KEY,
+source_file TEXT NOT NULL,
+status TEXT NOT NULL,
+started_at TIMESTAMPTZ NOT NULL,
+finished_at TIMESTAMPTZ NOT NULL,
+inserted INTEGER NOT NULL,
+updated INTEGER NOT NULL,
+failed INTEGER NOT NULL,
+sheets JSONB NOT NULL,
+error TEXT
+);`, table)
+	insert := fmt.Sprintf(`INSERT INTO %s (load_id, source_file, status, started_at, finished_at, inserted, updated, failed, sheets, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''))
+ON CONFLICT (load_id) DO UPDATE SET status = EXCLUDED.status, finished_at = EXCLUDED.finished_at,
+inserted = EXCLUDED.inserted, updated = EXCLUDED.updated, failed = EXCLUDED.failed,
+sheets = EXCLUDED.sheets, error = EXCLUDED.error`, table)
+
+	if _, err := pool.Exec(ctx, create); err != nil {
+		logger(ctx).Error("failed to create load catalog", "error", err)
+		return
+	}
+	_, err = pool.Exec(ctx, insert, result.LoadID, result.File, status, result.StartedAt, time.Now(),
+		result.Inserted, result.Updated, result.Failed, sheetsJSON, errText)
+	if err != nil {
+		logger(ctx).Error("failed to record load", "error", err)
+	}
+}
@@ -0,0 +1,350 @@
+package processXlsx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	cfg "xlsxtoSQL/config"
+	"xlsxtoSQL/postgres"
+	"xlsxtoSQL/tracing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/lib/pq"
+	"github.com/xuri/excelize/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ExportQuery is an SQL query exported to a sheet of its own.
+type ExportQuery struct {
+	Sheet string `json:"sheet"`
+	SQL   string `json:"sql"`
+}
+
+// ExportRequest names what to export. Without tables and queries every table
+// of the schema is exported.
+type ExportRequest struct {
+	Schema  string        `json:"schema"`
+	Tables  []string      `json:"tables,omitempty"`
+	Queries []ExportQuery `json:"queries,omitempty"`
+	// Sync adds the hidden _key and _version columns to the sheets of tables,
+	// so that loading the edited workbook applies only the changed cells.
+	Sync bool `json:"sync,omitempty"`
+}
+
+// ExportSheet describes a sheet of an exported workbook.
+type ExportSheet struct {
+	Sheet  string `json:"sheet"`
+	Source string `json:"source"`
+	Rows   int    `json:"rows"`
+}
+
+// Export writes the tables and query results of req to w as an .xlsx
+// workbook, one sheet per table or query. Nothing is written to w when an
+// error is returned. The database is only read, in a read only transaction,
+// which also keeps queries from writing.
+//
+// Tables are exported the way they can be loaded again: without id_row and
+// the lineage columns, and only with the current versions of a history mode
+// table. Times are written in UTC.
+func Export(ctx context.Context, config cfg.Config, req ExportRequest, w io.Writer) (sheets []ExportSheet, err error) {
+	ctx, span := tracing.Start(ctx, "Export", attribute.String("schema", req.Schema))
+	defer func() { tracing.End(span, err) }()
+
+	p, err := postgres.Open(ctx, config.PostgresURLBaseDB, config.Pool)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	tx, err := p.Pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin read only transaction: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+	if _, err := tx.Exec(ctx, "SET LOCAL TIME ZONE 'UTC'"); err != nil {
+		return nil, fmt.Errorf("failed to set time zone: %w", err)
+	}
+
+	tables := req.Tables
+	if len(tables) == 0 && len(req.Queries) == 0 {
+		if tables, err = schemaTables(ctx, tx, req.Schema); err != nil {
+			return nil, err
+		}
+		if len(tables) == 0 {
+			return nil, fmt.Errorf("schema %q has no tables", req.Schema)
+		}
+	}
+
+	xlsx := excelize.NewFile()
+	defer xlsx.Close()
+	styles, err := newExportStyles(xlsx)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+
+	for _, table := range tables {
+		query, err := tableExportQuery(ctx, tx, req.Schema, table, req.Sync)
+		if err != nil {
+			return nil, err
+		}
+		sheet := ExportSheet{Sheet: sheetName(table, names), Source: req.Schema + "." + table}
+		if sheet.Rows, err = exportSheet(ctx, tx, xlsx, styles, sheet.Sheet, query); err != nil {
+			return nil, fmt.Errorf("failed to export table %s: %w", table, err)
+		}
+		sheets = append(sheets, sheet)
+	}
+	for i, q := range req.Queries {
+		name := q.Sheet
+		if name == "" {
+			name = fmt.Sprintf("Query%d", i+1)
+		}
+		sheet := ExportSheet{Sheet: sheetName(name, names), Source: q.SQL}
+		if sheet.Rows, err = exportSheet(ctx, tx, xlsx, styles, sheet.Sheet, q.SQL); err != nil {
+			return nil, fmt.Errorf("failed to export query %q: %w", name, err)
+		}
+		sheets = append(sheets, sheet)
+	}
+
+	// NewFile starts with a sheet that no export is written to.
+	if !names["sheet1"] {
+		if err := xlsx.DeleteSheet("Sheet1"); err != nil {
+			return nil, err
+		}
+	}
+	if err := xlsx.Write(w); err != nil {
+		return nil, fmt.Errorf("failed to write workbook: %w", err)
+	}
+	return sheets, nil
+}
+
+func schemaTables(ctx context.Context, tx pgx.Tx, schema string) ([]string, error) {
+	rows, err := tx.Query(ctx, `SELECT table_name FROM information_schema.tables
+WHERE table_schema = $1 AND table_type = 'BASE TABLE' AND table_name NOT IN ($2, $3) ORDER BY table_name`,
+		schema, rejectsTable, loadsTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	tables, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	return tables, nil
+}
+
+// tableExportQuery selects the data columns of a table in row order and, for
+// sync, the _key and _version of each row.
+func tableExportQuery(ctx context.Context, tx pgx.Tx, schema, table string, sync bool) (string, error) {
+	rows, err := tx.Query(ctx, `SELECT column_name FROM information_schema.columns
+WHERE table_schema = $1 AND table_name = $2 ORDER BY ordinal_position`, schema, table)
+	if err != nil {
+		return "", fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	all, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	if len(all) == 0 {
+		return "", fmt.Errorf("table %s.%s not found", schema, table)
+	}
+
+	history := contains(all, "is_current")
+	var columns []string
+	for _, column := range all {
+		if column == "id_row" || isLineageColumn(column) || (history && contains(historyColumns, column)) {
+			continue
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("table %s.%s has no data columns", schema, table)
+	}
+
+	selects := strings.Join(quoteIdentifiers(columns), ", ")
+	if sync {
+		if history || !contains(all, "id_row") {
+			return "", fmt.Errorf("table %s.%s cannot be exported for sync: only upsert tables have a stable row key", schema, table)
+		}
+		selects += fmt.Sprintf(", id_row AS %s, %s AS %s", syncKeyColumn, versionSQL(columns), syncVersionColumn)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s.%s", selects, pq.QuoteIdentifier(schema), pq.QuoteIdentifier(table))
+	if history {
+		query += " WHERE is_current"
+	}
+	if contains(all, "id_row") {
+		query += " ORDER BY id_row"
+	}
+	return query, nil
+}
+
+// sheetName makes name a valid sheet name that is not used yet.
+func sheetName(name string, used map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, strings.Trim(name, "'"))
+	if name == "" {
+		name = "Sheet"
+	}
+	base := []rune(name)
+	if len(base) > excelize.MaxSheetNameLength {
+		base = base[:excelize.MaxSheetNameLength]
+	}
+	candidate := string(base)
+	for n := 2; used[strings.ToLower(candidate)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		candidate = string(base[:min(len(base), excelize.MaxSheetNameLength-len(suffix))]) + suffix
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+type exportStyles struct {
+	header, date, timestamp int
+}
+
+func newExportStyles(xlsx *excelize.File) (exportStyles, error) {
+	var s exportStyles
+	var err error
+	s.header, err = xlsx.NewStyle(&excelize.Style{
+		Font:   &excelize.Font{Bold: true},
+		Fill:   excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"D9E1F2"}},
+		Border: []excelize.Border{{Type: "bottom", Color: "8EA9DB", Style: 1}},
+	})
+	if err != nil {
+		return s, err
+	}
+	// ISO formats are read back as dates by a load, whatever the locale.
+	dateFormat, timestampFormat := "yyyy-mm-dd", "yyyy-mm-dd hh:mm:ss"
+	if s.date, err = xlsx.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat}); err != nil {
+		return s, err
+	}
+	s.timestamp, err = xlsx.NewStyle(&excelize.Style{CustomNumFmt: &timestampFormat})
+	return s, err
+}
+
+// exportSheet writes the result of query to a new sheet with a styled,
+// frozen header row and an auto filter, and returns the number of rows. The
+// sheet is built in memory: excelize's stream writer cannot add an auto
+// filter or hide columns.
+func exportSheet(ctx context.Context, tx pgx.Tx, xlsx *excelize.File, styles exportStyles, sheet, query string) (int, error) {
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	fields := rows.FieldDescriptions()
+	if len(fields) == 0 {
+		return 0, errors.New("query returns no columns")
+	}
+
+	if _, err := xlsx.NewSheet(sheet); err != nil {
+		return 0, err
+	}
+	header := make([]interface{}, len(fields))
+	for i, field := range fields {
+		header[i] = field.Name
+	}
+	if err := xlsx.SetSheetRow(sheet, "A1", &header); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for rows.Next() {
+		if count+1 >= excelize.TotalRows {
+			return 0, fmt.Errorf("more than %d rows do not fit in a sheet", excelize.TotalRows-1)
+		}
+		values, err := rows.Values()
+		if err != nil {
+			return 0, err
+		}
+		for i, v := range values {
+			values[i] = exportValue(v)
+		}
+		count++
+		cell, _ := excelize.CoordinatesToCellName(1, count+1)
+		if err := xlsx.SetSheetRow(sheet, cell, &values); err != nil {
+			return 0, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	lastCell, _ := excelize.CoordinatesToCellName(len(fields), count+1)
+	lastHeader, _ := excelize.CoordinatesToCellName(len(fields), 1)
+	if err := xlsx.SetCellStyle(sheet, "A1", lastHeader, styles.header); err != nil {
+		return 0, err
+	}
+	for i, field := range fields {
+		column, _ := excelize.ColumnNumberToName(i + 1)
+		width := float64(min(max(len(field.Name)+4, 12), 50))
+		if err := xlsx.SetColWidth(sheet, column, column, width); err != nil {
+			return 0, err
+		}
+		if isSyncColumn(field.Name) {
+			if err := xlsx.SetColVisible(sheet, column, false); err != nil {
+				return 0, err
+			}
+		}
+
+		style := 0
+		switch field.DataTypeOID {
+		case pgtype.DateOID:
+			style = styles.date
+		case pgtype.TimestampOID, pgtype.TimestamptzOID:
+			style = styles.timestamp
+		}
+		if style != 0 && count > 0 {
+			if err := xlsx.SetCellStyle(sheet, column+"2", fmt.Sprintf("%s%d", column, count+1), style); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := xlsx.SetPanes(sheet, &excelize.Panes{
+		Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft",
+	}); err != nil {
+		return 0, err
+	}
+	if err := xlsx.AutoFilter(sheet, "A1:"+lastCell, nil); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// exportValue converts a value scanned by pgx to one excelize writes as a
+// typed cell: numbers stay numbers and dates become date serials.
+func exportValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, bool, int16, int32, int64, float32, float64:
+		return v
+	case time.Time:
+		// Excel has no time zones.
+		return v.UTC()
+	case pgtype.Numeric:
+		f, err := v.Float64Value()
+		if err != nil || !f.Valid {
+			return nil
+		}
+		return f.Float64
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16])
+	case []byte:
+		return string(v)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
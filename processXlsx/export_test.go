@@ -0,0 +1,60 @@
+package processXlsx
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func Test_sheetName(t *testing.T) {
+	long := strings.Repeat("a", 40)
+	used := map[string]bool{}
+	tests := []struct {
+		name, want string
+	}{
+		{"orders", "orders"},
+		{"Orders", "Orders (2)"},
+		{"orders", "orders (3)"},
+		{"a/b:c*d?e[f]g\\h", "a_b_c_d_e_f_g_h"},
+		{"'quoted'", "quoted"},
+		{"", "Sheet"},
+		{"''", "Sheet (2)"},
+		{long, long[:31]},
+		{long, long[:27] + " (2)"},
+	}
+	for _, tt := range tests {
+		if got := sheetName(tt.name, used); got != tt.want {
+			t.Errorf("sheetName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_exportValue(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		v, want interface{}
+	}{
+		{nil, nil},
+		{"text", "text"},
+		{true, true},
+		{int32(7), int32(7)},
+		{1.5, 1.5},
+		{at, at.UTC()},
+		{pgtype.Numeric{Int: big.NewInt(1234), Exp: -2, Valid: true}, 12.34},
+		{pgtype.Numeric{}, nil},
+		{[16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0, 1, 2, 3, 4, 5, 6, 7}, "12345678-9abc-def0-0001-020304050607"},
+		{[]byte("raw"), "raw"},
+		{map[string]interface{}{"a": 1.0}, `{"a":1}`},
+		{[]interface{}{"x", 2.0}, `["x",2]`},
+		{uint8(3), "3"},
+	}
+	for _, tt := range tests {
+		if got := exportValue(tt.v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("exportValue(%#v) = %#v, want %#v", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package processXlsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	cfg "xlsxtoSQL/config"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+)
+
+// The columns history mode adds to a table next to the _row_hash lineage
+// column. The current version of a key has is_current set and no valid_to.
+var historyColumns = []string{"valid_from", "valid_to", "is_current"}
+var historyColumnTypes = []string{"TIMESTAMPTZ NOT NULL", "TIMESTAMPTZ", "BOOLEAN NOT NULL DEFAULT true"}
+
+type rowOutcome int
+
+const (
+	rowInserted rowOutcome = iota
+	rowUpdated
+	rowUnchanged
+)
+
+// errDuplicateKey rejects a row whose natural key already appeared in the
+// sheet: two versions cannot both be valid from the same load.
+var errDuplicateKey = errors.New("natural key is not unique in the sheet")
+
+// historyLoader writes the rows of a sheet as versions: a row whose key is
+// new is inserted, a changed row closes the current version of its key and
+// inserts a new one, an unchanged row is left alone.
+type historyLoader struct {
+	tx        pgx.Tx
+	schema    string
+	table     string
+	key       []string
+	validFrom time.Time
+	seen      map[string]bool
+	// current holds the id_row of the versions that are current after the
+	// rows loaded so far.
+	current []int64
+}
+
+// checkHistoryHeader checks that a sheet can be loaded in history mode.
+func checkHistoryHeader(table string, key, header []string) error {
+	for _, column := range historyColumns {
+		if contains(header, column) {
+			return fmt.Errorf("column %q of sheet %s is reserved in history mode", column, table)
+		}
+	}
+	for _, column := range key {
+		if !contains(header, column) {
+			return fmt.Errorf("sheet %s has no natural key column %q", table, column)
+		}
+	}
+	return nil
+}
+
+// historyTableSQL is createTableSQL for a table loaded in history mode.
+func historyTableSQL(schema, table string, columns, columnTypes []string) string {
+	return createTableSQL(schema, table,
+		append(append([]string{}, columns...), historyColumns...),
+		append(append([]string{}, columnTypes...), historyColumnTypes...))
+}
+
+// newHistoryLoader prepares table for history mode. The table must have been
+// created by a history load: upsert tables number their rows by position.
+func newHistoryLoader(ctx context.Context, tx pgx.Tx, schema, table string, key, header []string, validFrom time.Time) (*historyLoader, error) {
+	if err := checkHistoryHeader(table, key, header); err != nil {
+		return nil, err
+	}
+
+	var isHistory bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM information_schema.columns
+WHERE table_schema = $1 AND table_name = $2 AND column_name = 'is_current')`, schema, table).Scan(&isHistory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	if !isHistory {
+		return nil, fmt.Errorf("table %s was not created in history mode", table)
+	}
+
+	index := fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s.%s (%s) WHERE is_current",
+		pq.QuoteIdentifier(table+"_current_key"), pq.QuoteIdentifier(schema), pq.QuoteIdentifier(table),
+		strings.Join(quoteIdentifiers(key), ", "))
+	if _, err := tx.Exec(ctx, index); err != nil {
+		return nil, fmt.Errorf("failed to index the natural key of %s: %w", table, err)
+	}
+	return &historyLoader{
+		tx:        tx,
+		schema:    schema,
+		table:     table,
+		key:       key,
+		validFrom: validFrom,
+		seen:      map[string]bool{},
+	}, nil
+}
+
+// loadRow writes one row inside a savepoint. columns must include the
+// _row_hash lineage column.
+func (h *historyLoader) loadRow(ctx context.Context, columns, row []string, rowIndex int, columnTypes []string) (rowOutcome, error) {
+	if len(row) < len(columns) {
+		padded := make([]string, len(columns))
+		copy(padded, row)
+		row = padded
+	}
+	values := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if column != "" {
+			values[column] = cellValue(ctx, column, row[i], columnTypes[i], rowIndex)
+		}
+	}
+
+	keyValues := make([]string, len(h.key))
+	keyArgs := make([]interface{}, len(h.key))
+	conditions := make([]string, len(h.key))
+	for i, column := range h.key {
+		keyValues[i] = fmt.Sprint(values[column])
+		keyArgs[i] = values[column]
+		conditions[i] = fmt.Sprintf("%s IS NOT DISTINCT FROM $%d", pq.QuoteIdentifier(column), i+1)
+	}
+	keyID := historyKeyID(keyValues)
+	if h.seen[keyID] {
+		return 0, errDuplicateKey
+	}
+	h.seen[keyID] = true
+
+	table := pq.QuoteIdentifier(h.schema) + "." + pq.QuoteIdentifier(h.table)
+	var outcome rowOutcome
+	err := pgx.BeginFunc(ctx, h.tx, func(savepoint pgx.Tx) error {
+		var currentID int64
+		var currentHash *string
+		query := fmt.Sprintf("SELECT id_row, _row_hash FROM %s WHERE is_current AND %s FOR UPDATE",
+			table, strings.Join(conditions, " AND "))
+		err := savepoint.QueryRow(ctx, query, keyArgs...).Scan(&currentID, &currentHash)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			outcome = rowInserted
+		case err != nil:
+			return err
+		case currentHash != nil && *currentHash == values["_row_hash"]:
+			outcome = rowUnchanged
+			h.current = append(h.current, currentID)
+			return nil
+		default:
+			outcome = rowUpdated
+			closeQuery := fmt.Sprintf("UPDATE %s SET valid_to = $2, is_current = false WHERE id_row = $1", table)
+			if _, err := savepoint.Exec(ctx, closeQuery, currentID, h.validFrom); err != nil {
+				return err
+			}
+		}
+
+		names := []string{"valid_from", "valid_to", "is_current"}
+		args := []interface{}{h.validFrom, nil, true}
+		placeholders := []string{"$1", "$2", "$3"}
+		for _, column := range columns {
+			if column == "" {
+				continue
+			}
+			names = append(names, column)
+			args = append(args, values[column])
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id_row",
+			table, strings.Join(quoteIdentifiers(names), ", "), strings.Join(placeholders, ", "))
+		var id int64
+		if err := savepoint.QueryRow(ctx, insert, args...).Scan(&id); err != nil {
+			return err
+		}
+		h.current = append(h.current, id)
+		return nil
+	})
+	if err != nil {
+		logger(ctx).Warn("failed to insert row", "row", rowIndex+1, "error", err)
+		if isDataError(err) {
+			adjustColumnType(ctx, h.tx, h.schema, h.table, columns, columnTypes, row)
+		}
+		return 0, err
+	}
+	return outcome, nil
+}
+
+// historyKeyID identifies a natural key by its values.
+func historyKeyID(values []string) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprintf("%d:%s", len(value), value)
+	}
+	return strings.Join(parts, "\x1f")
+}
+
+// closeMissing closes the current versions of the keys that are not in the
+// sheet any more and returns how many were closed.
+func (h *historyLoader) closeMissing(ctx context.Context) (int, error) {
+	query := fmt.Sprintf("UPDATE %s.%s SET valid_to = $1, is_current = false WHERE is_current AND NOT (id_row = ANY($2))",
+		pq.QuoteIdentifier(h.schema), pq.QuoteIdentifier(h.table))
+	tag, err := h.tx.Exec(ctx, query, h.validFrom, h.current)
+	if err != nil {
+		return 0, fmt.Errorf("failed to close removed keys of %s: %w", h.table, err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// historyKey returns the natural key of a history mode config, nil in upsert
+// mode.
+func historyKey(config cfg.Config) []string {
+	if config.LoadMode != cfg.LoadModeHistory {
+		return nil
+	}
+	return config.NaturalKey
+}
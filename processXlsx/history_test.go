@@ -0,0 +1,106 @@
+package processXlsx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func Test_historyLoaderLoadRow(t *testing.T) {
+	columns := []string{"id", "name", "_row_hash"}
+	types := []string{"INTEGER", "TEXT", "TEXT"}
+	row := []string{"1", "a", rowHash([]string{"id", "name"}, []string{"1", "a"})}
+	same := row[2]
+	other := "0000"
+
+	tests := []struct {
+		name    string
+		rows    []fakeRow
+		want    rowOutcome
+		closes  int
+		current []int64
+	}{
+		{"new key", []fakeRow{{err: pgx.ErrNoRows}, {values: []interface{}{int64(9)}}}, rowInserted, 0, []int64{9}},
+		{"unchanged", []fakeRow{{values: []interface{}{int64(4), &same}}}, rowUnchanged, 0, []int64{4}},
+		{"changed", []fakeRow{{values: []interface{}{int64(4), &other}}, {values: []interface{}{int64(9)}}}, rowUpdated, 1, []int64{9}},
+		{"no hash yet", []fakeRow{{values: []interface{}{int64(4), (*string)(nil)}}, {values: []interface{}{int64(9)}}}, rowUpdated, 1, []int64{9}},
+	}
+	for _, tt := range tests {
+		tx := &fakeTx{rows: tt.rows}
+		h := &historyLoader{tx: tx, schema: "s", table: "t", key: []string{"id"}, validFrom: time.Now(), seen: map[string]bool{}}
+		got, err := h.loadRow(context.Background(), columns, row, 0, types)
+		if err != nil {
+			t.Fatalf("%s: loadRow() error = %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: loadRow() = %v, want %v", tt.name, got, tt.want)
+		}
+		if len(tx.execs) != tt.closes {
+			t.Errorf("%s: loadRow() closed %d versions, want %d: %q", tt.name, len(tx.execs), tt.closes, tx.execs)
+		}
+		if !reflect.DeepEqual(h.current, tt.current) {
+			t.Errorf("%s: current versions = %v, want %v", tt.name, h.current, tt.current)
+		}
+	}
+}
+
+func Test_historyLoaderDuplicateKey(t *testing.T) {
+	tx := &fakeTx{rows: []fakeRow{{err: pgx.ErrNoRows}, {values: []interface{}{int64(1)}}}}
+	h := &historyLoader{tx: tx, schema: "s", table: "t", key: []string{"id", "day"}, seen: map[string]bool{}}
+	columns := []string{"id", "day", "_row_hash"}
+	types := []string{"TEXT", "TEXT", "TEXT"}
+
+	if _, err := h.loadRow(context.Background(), columns, []string{"1", "2", "x"}, 0, types); err != nil {
+		t.Fatalf("loadRow() error = %v", err)
+	}
+	if _, err := h.loadRow(context.Background(), columns, []string{"1", "2", "y"}, 1, types); !errors.Is(err, errDuplicateKey) {
+		t.Errorf("loadRow() of a repeated key error = %v, want %v", err, errDuplicateKey)
+	}
+	// The key values are length-prefixed, so they do not run into each other.
+	if _, err := h.loadRow(context.Background(), columns, []string{"12", "", "z"}, 2, types); errors.Is(err, errDuplicateKey) {
+		t.Errorf("loadRow() of key (12, \"\") after (1, 2) reported a duplicate key")
+	}
+}
+
+func Test_historyLoaderCloseMissing(t *testing.T) {
+	validFrom := time.Now()
+	tx := &fakeTx{affected: 3}
+	h := &historyLoader{tx: tx, schema: "s", table: "t", validFrom: validFrom, current: []int64{4, 9}}
+
+	closed, err := h.closeMissing(context.Background())
+	if err != nil {
+		t.Fatalf("closeMissing() error = %v", err)
+	}
+	if closed != 3 {
+		t.Errorf("closeMissing() = %d, want 3", closed)
+	}
+	want := `UPDATE "s"."t" SET valid_to = $1, is_current = false WHERE is_current AND NOT (id_row = ANY($2))`
+	if len(tx.execs) != 1 || tx.execs[0] != want {
+		t.Fatalf("closeMissing() ran %q, want %q", tx.execs, want)
+	}
+	if !reflect.DeepEqual(tx.args[0], []interface{}{validFrom, []int64{4, 9}}) {
+		t.Errorf("closeMissing() arguments = %v, want the load time and the current versions", tx.args[0])
+	}
+}
+
+func Test_historyKeyID(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		same bool
+	}{
+		{[]string{"1", "2"}, []string{"1", "2"}, true},
+		{[]string{"1", "2"}, []string{"2", "1"}, false},
+		{[]string{"1", "23"}, []string{"12", "3"}, false},
+		{[]string{"a\x1fb"}, []string{"a", "b"}, false},
+		{[]string{""}, []string{}, false},
+	}
+	for _, tt := range tests {
+		if got := historyKeyID(tt.a) == historyKeyID(tt.b); got != tt.same {
+			t.Errorf("historyKeyID(%q) == historyKeyID(%q) is %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+	}
+}
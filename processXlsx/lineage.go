@@ -0,0 +1,112 @@
+package processXlsx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	cfg "xlsxtoSQL/config"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+)
+
+// lineage fills the system columns a job asked for.
+type lineage struct {
+	columns  []string
+	file     string
+	loadID   string
+	loadedAt time.Time
+}
+
+func newLineage(config cfg.Config, file, loadID string) *lineage {
+	columns := config.LineageColumns
+	// History mode compares versions by their hash.
+	if config.LoadMode == cfg.LoadModeHistory && !contains(columns, "_row_hash") {
+		columns = append(append([]string{}, columns...), "_row_hash")
+	}
+	return &lineage{columns: columns, file: file, loadID: loadID, loadedAt: time.Now()}
+}
+
+func isLineageColumn(column string) bool {
+	_, ok := cfg.LineageColumnTypes[column]
+	return ok
+}
+
+// addColumns adds the lineage columns missing from a table, whether it was
+// just created or loaded before lineage was configured.
+func (l *lineage) addColumns(ctx context.Context, tx pgx.Tx, schema, table string) error {
+	for _, column := range l.columns {
+		if _, err := tx.Exec(ctx, addLineageColumnSQL(schema, table, column)); err != nil {
+			return fmt.Errorf("failed to add lineage column %s to %s: %w", column, table, err)
+		}
+	}
+	return nil
+}
+
+func addLineageColumnSQL(schema, table, column string) string {
+	return fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS %s %s;",
+		pq.QuoteIdentifier(schema), pq.QuoteIdentifier(table),
+		pq.QuoteIdentifier(column), cfg.LineageColumnTypes[column])
+}
+
+// extend appends the lineage columns of a row to its columns, values and
+// types, ready for insertRow.
+func (l *lineage) extend(sheet string, excelRow int, columns, row, columnTypes []string) ([]string, []string, []string) {
+	if len(l.columns) == 0 {
+		return columns, row, columnTypes
+	}
+	n := len(columns) + len(l.columns)
+	extColumns := append(make([]string, 0, n), columns...)
+	extTypes := append(make([]string, 0, n), columnTypes...)
+	extRow := make([]string, len(columns), n)
+	copy(extRow, row)
+
+	for _, column := range l.columns {
+		var value string
+		switch column {
+		case "_source_file":
+			value = l.file
+		case "_source_sheet":
+			value = sheet
+		case "_source_row":
+			value = strconv.Itoa(excelRow)
+		case "_loaded_at":
+			value = l.loadedAt.Format(time.RFC3339Nano)
+		case "_load_id":
+			value = l.loadID
+		case "_row_hash":
+			value = rowHash(columns, row)
+		}
+		extColumns = append(extColumns, column)
+		extRow = append(extRow, value)
+		extTypes = append(extTypes, cfg.LineageColumnTypes[column])
+	}
+	return extColumns, extRow, extTypes
+}
+
+// rowHash is a SHA-256 over the named cells of a row. Cells are hashed in
+// the order of their column names, so a load and a retry of rejects, which
+// rebuilds rows from their stored cells, hash the same row alike.
+func rowHash(columns, row []string) string {
+	cells := make([][2]string, 0, len(columns))
+	for i, column := range columns {
+		if column == "" {
+			continue
+		}
+		value := ""
+		if i < len(row) {
+			value = row[i]
+		}
+		cells = append(cells, [2]string{column, value})
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i][0] < cells[j][0] })
+	h := sha256.New()
+	for _, cell := range cells {
+		fmt.Fprintf(h, "%d:%s=%d:%s\x1f", len(cell[0]), cell[0], len(cell[1]), cell[1])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
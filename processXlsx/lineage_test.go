@@ -0,0 +1,110 @@
+package processXlsx
+
+import (
+	"reflect"
+	"testing"
+	cfg "xlsxtoSQL/config"
+)
+
+// extendedHash returns the _row_hash lin.extend adds to a row.
+func extendedHash(t *testing.T, lin *lineage, columns, row, types []string) string {
+	t.Helper()
+	extColumns, extRow, _ := lin.extend("Sheet1", 2, columns, row, types)
+	for i, column := range extColumns {
+		if column == "_row_hash" {
+			return extRow[i]
+		}
+	}
+	t.Fatalf("extend(%q) added no _row_hash", columns)
+	return ""
+}
+
+func Test_rowHashRetry(t *testing.T) {
+	lin := newLineage(cfg.Config{LoadMode: cfg.LoadModeHistory, NaturalKey: []string{"id"}}, "book.xlsx", "load")
+	tests := []struct {
+		header, row []string
+	}{
+		{[]string{"name", "id", "amount"}, []string{"a", "1", "2.5"}},
+		{[]string{"zeta", "", "alpha"}, []string{"z", "ignored", "a"}},
+		{[]string{"id", "note", "day"}, []string{"7"}},
+	}
+	for _, tt := range tests {
+		types := make([]string, len(tt.header))
+		for i := range types {
+			types[i] = "TEXT"
+		}
+		loaded := extendedHash(t, lin, tt.header, tt.row, types)
+
+		tableTypes := map[string]string{}
+		for _, column := range tt.header {
+			tableTypes[column] = "text"
+		}
+		columns, row, rowTypes := quarantinedRow(rowValues(tt.header, tt.row), tableTypes)
+		retried := extendedHash(t, lin, columns, row, rowTypes)
+		if loaded != retried {
+			t.Errorf("header %q: retried row hashes to %s, loaded row to %s", tt.header, retried, loaded)
+		}
+	}
+}
+
+func Test_rowHash(t *testing.T) {
+	base := rowHash([]string{"id", "name"}, []string{"1", "a"})
+	tests := []struct {
+		columns, row []string
+		same         bool
+	}{
+		{[]string{"id", "name"}, []string{"1", "a"}, true},
+		{[]string{"name", "id"}, []string{"a", "1"}, true},
+		{[]string{"id", "", "name"}, []string{"1", "skipped", "a"}, true},
+		{[]string{"id", "name"}, []string{"1", "b"}, false},
+		{[]string{"id", "name"}, []string{"a", "1"}, false},
+		{[]string{"id", "name"}, []string{"1a", ""}, false},
+		{[]string{"id", "name", "note"}, []string{"1", "a"}, false},
+	}
+	for _, tt := range tests {
+		if got := rowHash(tt.columns, tt.row) == base; got != tt.same {
+			t.Errorf("rowHash(%q, %q) equal to the hash of id=1, name=a is %v, want %v", tt.columns, tt.row, got, tt.same)
+		}
+	}
+	if got, again := rowHash([]string{"x"}, []string{"y"}), rowHash([]string{"x"}, []string{"y"}); got != again {
+		t.Errorf("rowHash() is not stable: %s, then %s", got, again)
+	}
+}
+
+func Test_lineageExtend(t *testing.T) {
+	columns := []string{"id", "name"}
+	row := []string{"1", "a"}
+	types := []string{"INTEGER", "TEXT"}
+
+	lin := newLineage(cfg.Config{LineageColumns: []string{"_source_file", "_source_sheet", "_source_row", "_load_id", "_row_hash"}}, "book.xlsx", "load-1")
+	gotColumns, gotRow, gotTypes := lin.extend("Sheet1", 5, columns, row, types)
+
+	wantColumns := []string{"id", "name", "_source_file", "_source_sheet", "_source_row", "_load_id", "_row_hash"}
+	wantRow := []string{"1", "a", "book.xlsx", "Sheet1", "5", "load-1", rowHash(columns, row)}
+	if !reflect.DeepEqual(gotColumns, wantColumns) {
+		t.Errorf("extend() columns = %q, want %q", gotColumns, wantColumns)
+	}
+	if !reflect.DeepEqual(gotRow, wantRow) {
+		t.Errorf("extend() row = %q, want %q", gotRow, wantRow)
+	}
+	for i, column := range wantColumns[2:] {
+		if gotTypes[i+2] != cfg.LineageColumnTypes[column] {
+			t.Errorf("extend() type of %s = %q, want %q", column, gotTypes[i+2], cfg.LineageColumnTypes[column])
+		}
+	}
+	if !reflect.DeepEqual(gotTypes[:2], types) {
+		t.Errorf("extend() types of the sheet columns = %q, want %q", gotTypes[:2], types)
+	}
+
+	// A short row is padded, and the caller's slices are left alone.
+	short := []string{"2"}
+	_, gotRow, _ = lin.extend("Sheet1", 6, columns, short, types)
+	if gotRow[1] != "" || len(short) != 1 || len(columns) != 2 {
+		t.Errorf("extend() of a short row = %q, want name empty and the input untouched", gotRow)
+	}
+
+	none := newLineage(cfg.Config{}, "book.xlsx", "load-1")
+	if gotColumns, _, _ := none.extend("Sheet1", 2, columns, row, types); !reflect.DeepEqual(gotColumns, columns) {
+		t.Errorf("extend() without lineage columns = %q, want %q", gotColumns, columns)
+	}
+}
@@ -0,0 +1,189 @@
+package processXlsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+	cfg "xlsxtoSQL/config"
+	"xlsxtoSQL/postgres"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Lock is a PostgreSQL advisory lock that a load holds or waits for. Other
+// processes loading the same tables take the same keys, whatever their host.
+type Lock struct {
+	// ID tells apart the locks of this process.
+	ID     int64  `json:"id"`
+	Scope  string `json:"scope"`
+	Schema string `json:"schema"`
+	Table  string `json:"table,omitempty"`
+	Key    int64  `json:"key"`
+	// State is "waiting" or "held".
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+	// Holder is the session holding the lock while State is "waiting".
+	Holder *LockHolder `json:"holder,omitempty"`
+}
+
+// LockHolder describes the database session holding an advisory lock.
+type LockHolder struct {
+	PID             int        `json:"pid"`
+	ApplicationName string     `json:"application_name,omitempty"`
+	ClientAddr      string     `json:"client_addr,omitempty"`
+	Since           *time.Time `json:"since,omitempty"`
+}
+
+func (h *LockHolder) String() string {
+	if h == nil {
+		return "another session"
+	}
+	s := fmt.Sprintf("pid %d", h.PID)
+	if h.ApplicationName != "" {
+		s += " (" + h.ApplicationName + ")"
+	}
+	if h.ClientAddr != "" {
+		s += " from " + h.ClientAddr
+	}
+	return s
+}
+
+type locksKey struct{}
+
+// WithLocks registers a function receiving the advisory locks of the loader
+// whenever one is waited for, taken or, with released, let go. It is called
+// from the loading goroutines and must not block.
+func WithLocks(ctx context.Context, report func(lock Lock, released bool)) context.Context {
+	return context.WithValue(ctx, locksKey{}, report)
+}
+
+var lockIDs atomic.Int64
+
+// lockExecer is what advisory locks are taken on: the transaction of a sheet
+// or a connection held for a whole file.
+type lockExecer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// advisoryKey derives the lock key of a table, or of a schema when table is
+// empty.
+func advisoryKey(schema, table string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("xlsxtosql\x00" + schema + "\x00" + table))
+	return int64(h.Sum64())
+}
+
+// advisoryLock takes the advisory lock of schema and table, for the current
+// transaction of q when table is set and for the session of q otherwise.
+// With LockPolicySkip a lock held elsewhere is not waited for: skipped then
+// describes its holder. The returned function reports the lock released; a
+// session lock must be unlocked by the caller, see lockFile.
+func advisoryLock(ctx context.Context, q lockExecer, config cfg.LockConfig, schema, table string) (release func(), skipped string, err error) {
+	lock := Lock{ID: lockIDs.Add(1), Scope: cfg.LockScopeTable, Schema: schema, Table: table, Key: advisoryKey(schema, table)}
+	try, wait := "SELECT pg_try_advisory_xact_lock($1)", "SELECT pg_advisory_xact_lock($1)"
+	if table == "" {
+		lock.Scope = cfg.LockScopeFile
+		try, wait = "SELECT pg_try_advisory_lock($1)", "SELECT pg_advisory_lock($1)"
+	}
+	report, _ := ctx.Value(locksKey{}).(func(Lock, bool))
+	if report == nil {
+		report = func(Lock, bool) {}
+	}
+	release = func() { report(lock, true) }
+
+	var acquired bool
+	if err := q.QueryRow(ctx, try, lock.Key).Scan(&acquired); err != nil {
+		return nil, "", fmt.Errorf("failed to take %s lock: %w", lock.Scope, err)
+	}
+	if !acquired {
+		lock.Holder = lockHolder(ctx, q, lock.Key)
+		if config.Policy == cfg.LockPolicySkip {
+			return nil, fmt.Sprintf("%s lock held by %s", lock.Scope, lock.Holder), nil
+		}
+
+		lock.State, lock.Since = "waiting", time.Now()
+		report(lock, false)
+		logger(ctx).Info("waiting for lock", "scope", lock.Scope, "holder", lock.Holder.String())
+		waitCtx := ctx
+		if config.Timeout > 0 {
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithTimeout(ctx, config.Timeout)
+			defer cancel()
+		}
+		if _, err := q.Exec(waitCtx, wait, lock.Key); err != nil {
+			report(lock, true)
+			if errors.Is(waitCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+				return nil, "", fmt.Errorf("%s lock still held by %s after %s", lock.Scope, lock.Holder, config.Timeout)
+			}
+			return nil, "", fmt.Errorf("failed to take %s lock: %w", lock.Scope, err)
+		}
+		lock.Holder = nil
+	}
+	lock.State, lock.Since = "held", time.Now()
+	report(lock, false)
+	return release, "", nil
+}
+
+// lockHolder looks up the session holding the advisory lock key. It returns
+// nil when the session is gone or cannot be seen. The lookup runs in a
+// savepoint so that its failure leaves the transaction of q usable.
+func lockHolder(ctx context.Context, q lockExecer, key int64) *LockHolder {
+	// A bigint key is shown in pg_locks split in two halves.
+	var h LockHolder
+	err := pgx.BeginFunc(ctx, q, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `SELECT a.pid, COALESCE(a.application_name, ''), COALESCE(host(a.client_addr), ''),
+COALESCE(a.xact_start, a.backend_start)
+FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+AND l.classid::bigint = $1 AND l.objid::bigint = $2 LIMIT 1`,
+			int64(uint64(key)>>32), int64(uint32(key))).Scan(&h.PID, &h.ApplicationName, &h.ClientAddr, &h.Since)
+	})
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			logger(ctx).Debug("failed to look up lock holder", "error", err)
+		}
+		return nil
+	}
+	return &h
+}
+
+// lockFile takes the session lock of a schema on a connection that it keeps
+// until unlock is called, so that the lock covers every sheet of the file. The
+// connection is opened outside the pool of db: files loaded in parallel would
+// otherwise hold every pooled connection and leave none to their sheets.
+// skipped is set as by advisoryLock.
+func lockFile(ctx context.Context, db *postgres.PgStorage, config cfg.LockConfig, schema string) (unlock func(), skipped string, err error) {
+	var conn *pgx.Conn
+	err = db.Retry(ctx, "connect", func(ctx context.Context) error {
+		var err error
+		conn, err = pgx.ConnectConfig(ctx, db.Pool.Config().ConnConfig.Copy())
+		return err
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect for file lock: %w", err)
+	}
+	// Closing the connection ends its session, which releases the lock even
+	// when a cancelled wait took it.
+	release, skipped, err := advisoryLock(ctx, conn, config, schema, "")
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, "", err
+	}
+	if skipped != "" {
+		conn.Close(context.Background())
+		return nil, skipped, nil
+	}
+	return func() {
+		if err := conn.Close(context.Background()); err != nil {
+			logger(ctx).Warn("failed to close file lock connection", "error", err)
+		}
+		release()
+	}, "", nil
+}
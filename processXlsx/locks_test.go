@@ -0,0 +1,100 @@
+package processXlsx
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	cfg "xlsxtoSQL/config"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func Test_advisoryKey(t *testing.T) {
+	// Processes of every version must agree on the keys.
+	if got := advisoryKey("s", "t"); got != -86998794745203212 {
+		t.Errorf(`advisoryKey("s", "t") = %d, want -86998794745203212`, got)
+	}
+	if got := advisoryKey("s", ""); got != -9196063992154820536 {
+		t.Errorf(`advisoryKey("s", "") = %d, want -9196063992154820536`, got)
+	}
+
+	pairs := [][2]string{
+		{"s", ""}, {"s", "t"}, {"s", "u"}, {"t", ""}, {"t", "s"},
+		{"ab", "c"}, {"a", "bc"}, {"abc", ""}, {"", "abc"}, {"S", "t"},
+	}
+	keys := map[int64][2]string{}
+	for _, pair := range pairs {
+		key := advisoryKey(pair[0], pair[1])
+		if other, ok := keys[key]; ok {
+			t.Errorf("advisoryKey(%q) = advisoryKey(%q) = %d", pair, other, key)
+		}
+		keys[key] = pair
+	}
+}
+
+func Test_advisoryLock(t *testing.T) {
+	acquired := fakeRow{values: []interface{}{true}}
+	busy := fakeRow{values: []interface{}{false}}
+	noHolder := fakeRow{err: pgx.ErrNoRows}
+	tests := []struct {
+		name    string
+		table   string
+		policy  string
+		timeout time.Duration
+		block   bool
+		rows    []fakeRow
+		execs   []string
+		skipped string
+		err     string
+		states  []string
+	}{
+		{name: "free", table: "t", rows: []fakeRow{acquired}, states: []string{"held"}},
+		{name: "free file", rows: []fakeRow{acquired}, states: []string{"held"}},
+		{name: "wait", table: "t", policy: cfg.LockPolicyWait, rows: []fakeRow{busy, noHolder},
+			execs: []string{"SELECT pg_advisory_xact_lock($1)"}, states: []string{"waiting", "held"}},
+		{name: "wait file", policy: cfg.LockPolicyWait, rows: []fakeRow{busy, noHolder},
+			execs: []string{"SELECT pg_advisory_lock($1)"}, states: []string{"waiting", "held"}},
+		{name: "skip", table: "t", policy: cfg.LockPolicySkip, rows: []fakeRow{busy, noHolder},
+			skipped: "table lock held by another session"},
+		{name: "skip file", policy: cfg.LockPolicySkip, rows: []fakeRow{busy, noHolder},
+			skipped: "file lock held by another session"},
+		{name: "timeout", table: "t", policy: cfg.LockPolicyWait, timeout: time.Millisecond, block: true, rows: []fakeRow{busy, noHolder},
+			execs: []string{"SELECT pg_advisory_xact_lock($1)"}, err: "table lock still held by another session after 1ms",
+			states: []string{"waiting", "released"}},
+	}
+	for _, tt := range tests {
+		var states []string
+		ctx := WithLocks(context.Background(), func(lock Lock, released bool) {
+			if released {
+				states = append(states, "released")
+			} else {
+				states = append(states, lock.State)
+			}
+		})
+		tx := &fakeTx{rows: tt.rows, block: tt.block}
+		config := cfg.LockConfig{Policy: tt.policy, Timeout: tt.timeout}
+
+		release, skipped, err := advisoryLock(ctx, tx, config, "s", tt.table)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: advisoryLock() error = %v, want %q", tt.name, err, tt.err)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: advisoryLock() error = %v", tt.name, err)
+		}
+		if skipped != tt.skipped {
+			t.Errorf("%s: advisoryLock() skipped = %q, want %q", tt.name, skipped, tt.skipped)
+		}
+		if held := tt.err == "" && tt.skipped == ""; (release != nil) != held {
+			t.Errorf("%s: advisoryLock() returned a release: %v, want %v", tt.name, release != nil, held)
+		}
+		if !reflect.DeepEqual(tx.execs, tt.execs) {
+			t.Errorf("%s: advisoryLock() ran %q, want %q", tt.name, tx.execs, tt.execs)
+		}
+		if !reflect.DeepEqual(states, tt.states) {
+			t.Errorf("%s: reported states %q, want %q", tt.name, states, tt.states)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package processXlsx
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/xuri/excelize/v2"
+)
+
+// workbook serialises the reads of a workbook shared by the goroutines
+// loading its sheets.
+type workbook struct {
+	mu sync.Mutex
+	f  *excelize.File
+}
+
+func (w *workbook) rows(sheet string) ([][]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.GetRows(sheet)
+}
+
+// tableLocks keeps two loads of this process from writing the same table at
+// the same time, such as a file listed twice or jobs of the API server
+// loading the same workbook.
+var tableLocks = struct {
+	mu    sync.Mutex
+	locks map[string]*tableLock
+}{locks: map[string]*tableLock{}}
+
+type tableLock struct {
+	mu    sync.Mutex
+	users int
+}
+
+// lockTable waits until no other load of this process writes table and
+// returns the function releasing it. It stands in for the advisory locks
+// when the lock scope is none.
+func lockTable(schema, table string) func() {
+	key := schema + "\x00" + table
+	tableLocks.mu.Lock()
+	l, ok := tableLocks.locks[key]
+	if !ok {
+		l = &tableLock{}
+		tableLocks.locks[key] = l
+	}
+	l.users++
+	tableLocks.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		tableLocks.mu.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(tableLocks.locks, key)
+		}
+		tableLocks.mu.Unlock()
+	}
+}
+
+// sheetWorkers is how many sheets are loaded at the same time: each holds a
+// connection of pool for its transaction, so never more than the pool has.
+func sheetWorkers(configured int, pool *pgxpool.Pool) int {
+	limit := int(pool.Config().MaxConns)
+	if configured <= 0 || configured > limit {
+		return limit
+	}
+	return configured
+}
+
+// recovered runs fn and turns a panic into its error, so that a sheet or file
+// that trips a bug fails on its own instead of stopping the process.
+func recovered(ctx context.Context, fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger(ctx).Error("recovered from panic", "panic", p, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,405 @@
+package processXlsx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	cfg "xlsxtoSQL/config"
+	"xlsxtoSQL/datatype"
+	"xlsxtoSQL/postgres"
+	"xlsxtoSQL/rules"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+	"github.com/xuri/excelize/v2"
+)
+
+// planSamples is the number of sample values shown per column.
+const planSamples = 3
+
+// ColumnPlan shows the type inferred for a column next to the type it has in
+// the database.
+type ColumnPlan struct {
+	Name         string   `json:"name"`
+	DetectedType string   `json:"detected_type"`
+	ExistingType string   `json:"existing_type,omitempty"`
+	Samples      []string `json:"samples"`
+}
+
+// SheetPlan is what loading a sheet would change.
+type SheetPlan struct {
+	Sheet   string       `json:"sheet"`
+	Table   string       `json:"table"`
+	Create  string       `json:"create,omitempty"`
+	Columns []ColumnPlan `json:"columns"`
+	// Alters are the ALTER statements adjustColumnType would run for rows
+	// whose values do not fit the current column types.
+	Alters   []string `json:"alters,omitempty"`
+	Rows     int      `json:"rows"`
+	Inserted int      `json:"inserted"`
+	Updated  int      `json:"updated"`
+	Rejected int      `json:"rejected"`
+	// Deleted counts the rows of the table that are not in the sheet any
+	// more. Loads upsert and never delete them.
+	Deleted int `json:"deleted"`
+	// Unchanged and Closed count, in history mode, the keys whose current
+	// version is kept and the keys not in the sheet any more.
+	Unchanged int      `json:"unchanged,omitempty"`
+	Closed    int      `json:"closed,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Plan is what loading a workbook would change.
+type Plan struct {
+	File         string      `json:"file"`
+	Schema       string      `json:"schema"`
+	CreateSchema string      `json:"create_schema,omitempty"`
+	LoadMode     string      `json:"load_mode,omitempty"`
+	Sheets       []SheetPlan `json:"sheets"`
+	Error        string      `json:"error,omitempty"`
+}
+
+// PlanExcelFile computes what ProcessExcelFile would do to the database
+// without writing anything: the database is only read, in a read only
+// transaction.
+func PlanExcelFile(ctx context.Context, config cfg.Config, file string) (Plan, error) {
+	plan := Plan{File: file, Schema: config.SchemaFor(file), LoadMode: config.LoadMode}
+	err := planExcelFile(ctx, config, file, &plan)
+	if err != nil {
+		plan.Error = err.Error()
+	}
+	return plan, err
+}
+
+func planExcelFile(ctx context.Context, config cfg.Config, file string, plan *Plan) error {
+	ruleSet, err := rules.Compile(config.Rules)
+	if err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+	path, err := config.ResolveFile(file)
+	if err != nil {
+		return err
+	}
+	xlsx, err := excelize.OpenFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to open XLSX file: %w", err)
+	}
+	defer xlsx.Close()
+
+	p, err := postgres.Open(ctx, config.PostgresURLBaseDB, config.Pool)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	tx, err := p.Pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("failed to begin read only transaction: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var exists bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM information_schema.schemata WHERE schema_name = $1)", plan.Schema).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up schema: %w", err)
+	}
+	if !exists {
+		plan.CreateSchema = fmt.Sprintf("CREATE SCHEMA %s;", pq.QuoteIdentifier(plan.Schema))
+	}
+
+	lin := newLineage(config, file, "")
+	for _, sheetName := range xlsx.GetSheetList() {
+		if sheetName == "" || contains(reservedSheets, sheetName) || contains(config.IgnorantSheets, sheetName) {
+			continue
+		}
+		sheet := SheetPlan{Sheet: sheetName, Table: pq.QuoteIdentifier(plan.Schema) + "." + pq.QuoteIdentifier(sheetName)}
+		if err := planSheet(ctx, tx, xlsx, ruleSet, lin, historyKey(config), plan.Schema, exists, &sheet); err != nil {
+			sheet.Error = err.Error()
+		}
+		plan.Sheets = append(plan.Sheets, sheet)
+	}
+	return nil
+}
+
+func planSheet(ctx context.Context, tx pgx.Tx, xlsx *excelize.File, ruleSet *rules.Set, lin *lineage, key []string, schema string, schemaExists bool, sheet *SheetPlan) error {
+	rows, err := xlsx.GetRows(sheet.Sheet)
+	if err != nil {
+		return fmt.Errorf("failed to read sheet: %w", err)
+	}
+	if len(rows) < 2 {
+		sheet.Warnings = append(sheet.Warnings, "sheet is empty or has an invalid header row, it is skipped")
+		return nil
+	}
+	headerRow := rows[0]
+	dataRows := rows[1:]
+	columnTypes := detectColumnTypes(headerRow, dataRows)
+	sheet.Rows = len(dataRows)
+	if isSyncSheet(headerRow) {
+		if key != nil {
+			return fmt.Errorf("sheet %s was exported for sync, which history mode does not support", sheet.Sheet)
+		}
+		sheet.Warnings = append(sheet.Warnings,
+			"sheet was exported for sync: only changed cells are written, the plan shows an upsert")
+	}
+	if key != nil {
+		if err := checkHistoryHeader(sheet.Sheet, key, headerRow); err != nil {
+			return err
+		}
+	}
+
+	existing := map[string]string{}
+	if schemaExists {
+		var queryErr error
+		existing, queryErr = tableColumnTypes(ctx, tx, schema, sheet.Sheet)
+		if queryErr != nil {
+			// tableColumnTypes fails on a missing table.
+			existing = map[string]string{}
+		}
+	}
+	tableExists := len(existing) > 0
+	if key != nil && tableExists {
+		if _, ok := existing["is_current"]; !ok {
+			return fmt.Errorf("table %s was not created in history mode", sheet.Sheet)
+		}
+	}
+
+	for i, column := range headerRow {
+		if column == "" {
+			continue
+		}
+		c := ColumnPlan{Name: column, DetectedType: columnTypes[i], ExistingType: existing[column], Samples: []string{}}
+		for _, row := range dataRows {
+			if len(c.Samples) == planSamples {
+				break
+			}
+			if i < len(row) && strings.TrimSpace(row[i]) != "" {
+				c.Samples = append(c.Samples, row[i])
+			}
+		}
+		if tableExists && c.ExistingType == "" {
+			sheet.Warnings = append(sheet.Warnings,
+				fmt.Sprintf("column %q is not in the table, every row would fail", column))
+		}
+		sheet.Columns = append(sheet.Columns, c)
+	}
+
+	switch {
+	case tableExists:
+	case key != nil:
+		sheet.Create = historyTableSQL(schema, sheet.Sheet, headerRow, columnTypes)
+	default:
+		sheet.Create = createTableSQL(schema, sheet.Sheet, headerRow, columnTypes)
+	}
+	for _, column := range lin.columns {
+		if _, ok := existing[column]; !ok {
+			sheet.Alters = append(sheet.Alters, addLineageColumnSQL(schema, sheet.Sheet, column))
+		}
+	}
+
+	validator, err := ruleSet.ForSheet(sheet.Sheet, headerRow)
+	if err != nil {
+		return err
+	}
+
+	ids := map[int]bool{}
+	// versions maps the natural keys of the current versions to their row
+	// hash in history mode.
+	versions := map[string]string{}
+	seen := map[string]bool{}
+	if tableExists && key != nil {
+		versions, err = currentVersions(ctx, tx, sheet.Table, key, existing)
+		if err != nil {
+			return err
+		}
+	} else if tableExists {
+		query := fmt.Sprintf("SELECT id_row FROM %s", sheet.Table)
+		rows, err := tx.Query(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to read existing rows: %w", err)
+		}
+		existingIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			return fmt.Errorf("failed to read existing rows: %w", err)
+		}
+		for _, id := range existingIDs {
+			ids[id] = true
+		}
+	}
+
+	// The types the table has while loading, as adjustColumnType changes them.
+	current := make([]string, len(headerRow))
+	for i, column := range headerRow {
+		current[i] = columnTypes[i]
+		if t, ok := existing[column]; ok {
+			current[i] = t
+		}
+	}
+	alters := map[string]bool{}
+
+	for rowIndex, row := range dataRows {
+		if !validator.Empty() {
+			var scratch SheetResult
+			reject, err := validateRow(ctx, validator, &scratch, rowIndex+2, row)
+			if err != nil {
+				sheet.Warnings = append(sheet.Warnings, err.Error()+", the sheet would be rolled back")
+				return nil
+			}
+			if reject != nil {
+				sheet.Rejected++
+				continue
+			}
+		}
+
+		if column := offendingColumn(headerRow, current, row); column != "" {
+			// insertRow would fail and adjustColumnType try to widen every
+			// column whose value in this row has another type.
+			for i, name := range headerRow {
+				if name == "" || i >= len(row) {
+					continue
+				}
+				newType := datatype.DetermineType(row[i])
+				if newType == current[i] {
+					continue
+				}
+				alter := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DATA TYPE %s USING %s::%s;",
+					sheet.Table, pq.QuoteIdentifier(name), newType, pq.QuoteIdentifier(name), newType)
+				if !alters[alter] {
+					alters[alter] = true
+					sheet.Alters = append(sheet.Alters, alter)
+				}
+				current[i] = newType
+			}
+			sheet.Rejected++
+			continue
+		}
+
+		if key != nil {
+			keyValues := make([]string, len(key))
+			for i, column := range key {
+				index := indexOf(headerRow, column)
+				value := ""
+				if index < len(row) {
+					value = row[index]
+				}
+				keyValues[i] = cellValue(ctx, column, value, columnTypes[index], rowIndex)
+			}
+			id := historyKeyID(keyValues)
+			if seen[id] {
+				// The loader rejects it with errDuplicateKey.
+				sheet.Rejected++
+				continue
+			}
+			seen[id] = true
+			hash, ok := versions[id]
+			switch {
+			case !ok:
+				sheet.Inserted++
+			case hash == rowHash(headerRow, row):
+				sheet.Unchanged++
+			default:
+				sheet.Updated++
+			}
+			delete(versions, id)
+			continue
+		}
+
+		if ids[rowIndex+1] {
+			sheet.Updated++
+			delete(ids, rowIndex+1)
+		} else {
+			sheet.Inserted++
+		}
+	}
+	sheet.Deleted = len(ids)
+	sheet.Closed = len(versions)
+	return nil
+}
+
+// currentVersions reads the natural keys and row hashes of the current
+// versions of a history table. Keys are compared in their text form.
+func currentVersions(ctx context.Context, tx pgx.Tx, table string, key []string, existing map[string]string) (map[string]string, error) {
+	selects := make([]string, 0, len(key)+1)
+	for _, column := range key {
+		selects = append(selects, pq.QuoteIdentifier(column)+"::text")
+	}
+	hash := "NULL::text"
+	if _, ok := existing["_row_hash"]; ok {
+		hash = "_row_hash"
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE is_current", strings.Join(selects, ", "), hash, table)
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current versions: %w", err)
+	}
+	defer rows.Close()
+	versions := map[string]string{}
+	for rows.Next() {
+		values := make([]*string, len(key)+1)
+		dest := make([]interface{}, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to read current versions: %w", err)
+		}
+		keyValues := make([]string, len(key))
+		for i := range key {
+			if values[i] != nil {
+				keyValues[i] = *values[i]
+			}
+		}
+		var rowHash string
+		if values[len(key)] != nil {
+			rowHash = *values[len(key)]
+		}
+		versions[historyKeyID(keyValues)] = rowHash
+	}
+	return versions, rows.Err()
+}
+
+// WriteText prints the plan as a diff: "+" for what would be created, "~" for
+// what would change.
+func (p Plan) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "file %s -> schema %s\n", p.File, pq.QuoteIdentifier(p.Schema))
+	if p.Error != "" {
+		fmt.Fprintf(w, "  ! %s\n", p.Error)
+		return
+	}
+	if p.CreateSchema != "" {
+		fmt.Fprintf(w, "+ %s\n", p.CreateSchema)
+	}
+	for _, s := range p.Sheets {
+		fmt.Fprintf(w, "\nsheet %s -> %s\n", s.Sheet, s.Table)
+		if s.Error != "" {
+			fmt.Fprintf(w, "  ! %s\n", s.Error)
+			continue
+		}
+		if s.Create != "" {
+			for _, line := range strings.Split(s.Create, "\n") {
+				fmt.Fprintf(w, "+ %s\n", line)
+			}
+		}
+		for _, c := range s.Columns {
+			existing := c.ExistingType
+			if existing == "" {
+				existing = "-"
+			}
+			fmt.Fprintf(w, "  %-24s %-8s (table: %s) %s\n", c.Name, c.DetectedType, existing, strings.Join(c.Samples, ", "))
+		}
+		for _, alter := range s.Alters {
+			fmt.Fprintf(w, "~ %s\n", alter)
+		}
+		if p.LoadMode == cfg.LoadModeHistory {
+			fmt.Fprintf(w, "  rows: %d, +%d inserted, ~%d updated, =%d unchanged, %d rejected, %d keys closed\n",
+				s.Rows, s.Inserted, s.Updated, s.Unchanged, s.Rejected, s.Closed)
+		} else {
+			fmt.Fprintf(w, "  rows: %d, +%d inserted, ~%d updated, %d rejected, %d in the table only (kept)\n",
+				s.Rows, s.Inserted, s.Updated, s.Rejected, s.Deleted)
+		}
+		for _, warning := range s.Warnings {
+			fmt.Fprintf(w, "  ! %s\n", warning)
+		}
+	}
+}
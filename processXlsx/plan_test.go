@@ -0,0 +1,199 @@
+package processXlsx
+
+import (
+	"context"
+	"strings"
+	"testing"
+	cfg "xlsxtoSQL/config"
+	"xlsxtoSQL/rules"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// planWorkbook returns a workbook whose sheet Data holds rows.
+func planWorkbook(t *testing.T, rows [][]string) *excelize.File {
+	t.Helper()
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", "Data"); err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow("Data", cell, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func columnRows(types ...string) []fakeRow {
+	rows := make([]fakeRow, 0, len(types)/2)
+	for i := 0; i < len(types); i += 2 {
+		rows = append(rows, fakeRow{values: []interface{}{types[i], types[i+1]}})
+	}
+	return rows
+}
+
+func Test_planSheet(t *testing.T) {
+	header := []string{"id", "name"}
+	stale, missing := "stale", "x"
+	unchanged := rowHash(header, []string{"1", "a"})
+	tests := []struct {
+		name         string
+		key          []string
+		schemaExists bool
+		results      [][]fakeRow
+		rows         [][]string
+		want         SheetPlan
+		create       bool
+		alters       int
+	}{
+		{
+			name:   "new schema",
+			rows:   [][]string{{"1", "a"}, {"2", "b"}},
+			want:   SheetPlan{Rows: 2, Inserted: 2},
+			create: true,
+		},
+		{
+			name:         "new table",
+			schemaExists: true,
+			rows:         [][]string{{"1", "a"}},
+			want:         SheetPlan{Rows: 1, Inserted: 1},
+			create:       true,
+		},
+		{
+			name:         "upsert",
+			schemaExists: true,
+			results: [][]fakeRow{
+				columnRows("id_row", "integer", "id", "integer", "name", "text"),
+				{{values: []interface{}{1}}, {values: []interface{}{3}}, {values: []interface{}{7}}},
+			},
+			rows: [][]string{{"1", "a"}, {"2", "b"}, {"3", "c"}},
+			want: SheetPlan{Rows: 3, Inserted: 1, Updated: 2, Deleted: 1},
+		},
+		{
+			name:         "values that do not fit",
+			schemaExists: true,
+			results:      [][]fakeRow{columnRows("id_row", "integer", "id", "integer", "name", "text")},
+			// Once the ALTER widened id, the next text value fits.
+			rows:   [][]string{{"1", "a"}, {"x", "b"}, {"y", "c"}},
+			want:   SheetPlan{Rows: 3, Inserted: 2, Rejected: 1},
+			alters: 1,
+		},
+		{
+			name:         "history",
+			key:          []string{"id"},
+			schemaExists: true,
+			results: [][]fakeRow{
+				columnRows("id_row", "integer", "id", "integer", "name", "text", "is_current", "boolean", "_row_hash", "text"),
+				{
+					{values: []interface{}{strp("1"), &unchanged}},
+					{values: []interface{}{strp("2"), &stale}},
+					{values: []interface{}{strp("9"), &missing}},
+				},
+			},
+			rows: [][]string{{"1", "a"}, {"2", "b"}, {"3", "c"}, {"3", "d"}},
+			want: SheetPlan{Rows: 4, Inserted: 1, Updated: 1, Unchanged: 1, Rejected: 1, Closed: 1},
+		},
+	}
+	for _, tt := range tests {
+		ruleSet, err := rules.Compile(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := cfg.Config{}
+		if tt.key != nil {
+			config = cfg.Config{LoadMode: cfg.LoadModeHistory, NaturalKey: tt.key}
+		}
+		xlsx := planWorkbook(t, append([][]string{header}, tt.rows...))
+		tx := &fakeTx{results: tt.results}
+		sheet := SheetPlan{Sheet: "Data", Table: `"s"."Data"`}
+
+		if err := planSheet(context.Background(), tx, xlsx, ruleSet, newLineage(config, "book.xlsx", ""), tt.key, "s", tt.schemaExists, &sheet); err != nil {
+			t.Fatalf("%s: planSheet() error = %v", tt.name, err)
+		}
+		if got, want := planCounts(sheet), planCounts(tt.want); got != want {
+			t.Errorf("%s: planSheet() counts = %v, want %v", tt.name, got, want)
+		}
+		if (sheet.Create != "") != tt.create {
+			t.Errorf("%s: planSheet() create = %q, want a CREATE TABLE: %v", tt.name, sheet.Create, tt.create)
+		}
+		if len(sheet.Alters) != tt.alters {
+			t.Errorf("%s: planSheet() alters = %q, want %d", tt.name, sheet.Alters, tt.alters)
+		}
+	}
+}
+
+func strp(s string) *string { return &s }
+
+// planCounts returns rows, inserted, updated, rejected, deleted, unchanged
+// and closed.
+func planCounts(s SheetPlan) [7]int {
+	return [7]int{s.Rows, s.Inserted, s.Updated, s.Rejected, s.Deleted, s.Unchanged, s.Closed}
+}
+
+func Test_planWriteText(t *testing.T) {
+	sheet := SheetPlan{
+		Sheet:   "Data",
+		Table:   `"s"."Data"`,
+		Columns: []ColumnPlan{{Name: "id", DetectedType: "INTEGER", ExistingType: "INTEGER", Samples: []string{"1", "2"}}, {Name: "note", DetectedType: "TEXT"}},
+		Alters:  []string{`ALTER TABLE "s"."Data" ALTER COLUMN "id" SET DATA TYPE TEXT USING "id"::TEXT;`},
+		Rows:    4, Inserted: 1, Updated: 1, Unchanged: 1, Rejected: 1, Deleted: 2, Closed: 3,
+		Warnings: []string{"careful"},
+	}
+	tests := []struct {
+		name string
+		plan Plan
+		want string
+	}{
+		{
+			name: "upsert",
+			plan: Plan{File: "book.xlsx", Schema: "s", CreateSchema: `CREATE SCHEMA "s";`, Sheets: []SheetPlan{sheet}},
+			want: `file book.xlsx -> schema "s"
++ CREATE SCHEMA "s";
+
+sheet Data -> "s"."Data"
+  id                       INTEGER  (table: INTEGER) 1, 2
+  note                     TEXT     (table: -) 
+~ ALTER TABLE "s"."Data" ALTER COLUMN "id" SET DATA TYPE TEXT USING "id"::TEXT;
+  rows: 4, +1 inserted, ~1 updated, 1 rejected, 2 in the table only (kept)
+  ! careful
+`,
+		},
+		{
+			name: "history",
+			plan: Plan{File: "book.xlsx", Schema: "s", LoadMode: cfg.LoadModeHistory, Sheets: []SheetPlan{{Sheet: "Data", Table: `"s"."Data"`, Create: "CREATE TABLE t (\n  a TEXT\n);", Rows: 4, Inserted: 1, Updated: 1, Unchanged: 1, Rejected: 1, Closed: 3}}},
+			want: `file book.xlsx -> schema "s"
+
+sheet Data -> "s"."Data"
++ CREATE TABLE t (
++   a TEXT
++ );
+  rows: 4, +1 inserted, ~1 updated, =1 unchanged, 1 rejected, 3 keys closed
+`,
+		},
+		{
+			name: "sheet error",
+			plan: Plan{File: "book.xlsx", Schema: "s", Sheets: []SheetPlan{{Sheet: "Data", Table: `"s"."Data"`, Error: "broken"}}},
+			want: `file book.xlsx -> schema "s"
+
+sheet Data -> "s"."Data"
+  ! broken
+`,
+		},
+		{
+			name: "file error",
+			plan: Plan{File: "book.xlsx", Schema: "s", Error: "failed to open XLSX file", Sheets: []SheetPlan{sheet}},
+			want: `file book.xlsx -> schema "s"
+  ! failed to open XLSX file
+`,
+		},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		tt.plan.WriteText(&b)
+		if b.String() != tt.want {
+			t.Errorf("%s: WriteText() =\n%s\nwant\n%s", tt.name, b.String(), tt.want)
+		}
+	}
+}
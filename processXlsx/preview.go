@@ -0,0 +1,113 @@
+package processXlsx
+
+import (
+	"fmt"
+	"strings"
+	"xlsxtoSQL/datatype"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ColumnPreview shows the type the loader would give a column. Confidence is
+// the share of non-empty values that have that type themselves: a TEXT
+// column with a low confidence mostly holds numbers or dates, and a few
+// stray values force it to TEXT.
+type ColumnPreview struct {
+	Name       string         `json:"name"`
+	Type       string         `json:"type"`
+	Confidence float64        `json:"confidence"`
+	Values     int            `json:"values"`
+	Empty      int            `json:"empty"`
+	ValueTypes map[string]int `json:"value_types"`
+}
+
+// SheetPreview describes a sheet of a workbook.
+type SheetPreview struct {
+	Name      string          `json:"name"`
+	Dimension string          `json:"dimension"`
+	Rows      int             `json:"rows"`
+	Header    []string        `json:"header"`
+	Columns   []ColumnPreview `json:"columns"`
+	Sample    [][]string      `json:"sample"`
+	// Skipped explains why the loader would not load the sheet.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// PreviewExcelFile reads a workbook and describes each sheet with up to
+// sampleRows data rows. It does not need a database.
+func PreviewExcelFile(path string, sampleRows int) ([]SheetPreview, error) {
+	xlsx, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLSX file: %w", err)
+	}
+	defer xlsx.Close()
+
+	previews := []SheetPreview{}
+	for _, name := range xlsx.GetSheetList() {
+		preview, err := previewSheet(xlsx, name, sampleRows)
+		if err != nil {
+			return nil, err
+		}
+		previews = append(previews, preview)
+	}
+	return previews, nil
+}
+
+func previewSheet(xlsx *excelize.File, name string, sampleRows int) (SheetPreview, error) {
+	preview := SheetPreview{Name: name, Header: []string{}, Columns: []ColumnPreview{}, Sample: [][]string{}}
+	preview.Dimension, _ = xlsx.GetSheetDimension(name)
+
+	rows, err := xlsx.GetRows(name)
+	if err != nil {
+		return preview, fmt.Errorf("failed to read sheet %s: %w", name, err)
+	}
+	if contains(reservedSheets, name) {
+		preview.Skipped = fmt.Sprintf("the name is reserved for the %s system table", name)
+	}
+	if len(rows) == 0 {
+		if preview.Skipped == "" {
+			preview.Skipped = "the sheet is empty"
+		}
+		return preview, nil
+	}
+
+	preview.Header = rows[0]
+	dataRows := rows[1:]
+	preview.Rows = len(dataRows)
+	if len(dataRows) == 0 && preview.Skipped == "" {
+		preview.Skipped = "the sheet has no data rows"
+	}
+	for i := 0; i < len(dataRows) && i < sampleRows; i++ {
+		preview.Sample = append(preview.Sample, dataRows[i])
+	}
+
+	// DetectColumnTypes sizes its result by the first data row.
+	columnTypes := datatype.DetectColumnTypes(dataRows)
+	for i, column := range preview.Header {
+		if column == "" {
+			continue
+		}
+		c := ColumnPreview{Name: column, Type: "TEXT", ValueTypes: map[string]int{}}
+		if i < len(columnTypes) {
+			c.Type = columnTypes[i]
+		}
+		matching := 0
+		for _, row := range dataRows {
+			if i >= len(row) || strings.TrimSpace(row[i]) == "" {
+				c.Empty++
+				continue
+			}
+			valueType := datatype.DetermineType(row[i])
+			c.Values++
+			c.ValueTypes[valueType]++
+			if valueType == c.Type || (c.Type != "TEXT" && fitsType(row[i], c.Type)) {
+				matching++
+			}
+		}
+		if c.Values > 0 {
+			c.Confidence = float64(matching) / float64(c.Values)
+		}
+		preview.Columns = append(preview.Columns, c)
+	}
+	return preview, nil
+}
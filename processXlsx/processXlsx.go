@@ -2,58 +2,201 @@ package processXlsx
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"strings"
+	"sync"
+	"time"
 	cfg "xlsxtoSQL/config"
 	"xlsxtoSQL/datatype"
+	"xlsxtoSQL/metrics"
 	"xlsxtoSQL/postgres"
+	"xlsxtoSQL/rules"
+	"xlsxtoSQL/tracing"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lib/pq"
 	"github.com/xuri/excelize/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ProcessExcelFile(config cfg.Config, file string) error {
-	xlsx, err := excelize.OpenFile(file)
+// ProcessExcelFile loads every sheet of file into its own table and reports
+// what was written. Each sheet is written in a single transaction, so
+// cancelling ctx rolls back the sheet in progress and leaves the tables loaded
+// before it intact. The connections come from db, which the caller owns so
+// that its pool outlives a single file.
+func ProcessExcelFile(ctx context.Context, db *postgres.PgStorage, config cfg.Config, file string) (Result, error) {
+	id, ok := loadID(ctx)
+	if !ok {
+		id = newLoadID()
+		ctx = WithLoadID(ctx, id)
+	}
+	ctx, span := tracing.Start(ctx, "ProcessExcelFile",
+		attribute.String("file", file), attribute.String("load_id", id))
+	result := Result{File: file, LoadID: id, StartedAt: time.Now()}
+	err := recovered(ctx, func() error {
+		return processExcelFile(ctx, db, config, file, &result)
+	})
+	result.finish(err)
+	observeResult(result)
+	span.SetAttributes(attribute.Int("inserted", result.Inserted),
+		attribute.Int("updated", result.Updated), attribute.Int("failed", result.Failed))
+	tracing.End(span, err)
+	return result, err
+}
+
+// observeResult exports the duration of a load and, when no sheet failed,
+// the time of the last successful load of the file.
+func observeResult(result Result) {
+	status := "success"
+	if result.Error != "" {
+		status = "failed"
+	}
+	for _, sheet := range result.Sheets {
+		if sheet.Error != "" {
+			status = "failed"
+		}
+	}
+	metrics.LoadDuration.WithLabelValues(result.File, status).Observe(result.DurationSeconds)
+	if status == "success" {
+		metrics.LastSuccess.WithLabelValues(result.File).Set(float64(result.FinishedAt.Unix()))
+	}
+}
+
+func processExcelFile(ctx context.Context, db *postgres.PgStorage, config cfg.Config, file string, result *Result) (err error) {
+	ctx = WithLogger(ctx, logger(ctx).With("file", file))
+
+	ruleSet, err := rules.Compile(config.Rules)
+	if err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+
+	path, err := config.ResolveFile(file)
+	if err != nil {
+		return err
+	}
+	_, openSpan := tracing.Start(ctx, "excelize.OpenFile")
+	xlsx, err := excelize.OpenFile(path)
+	tracing.End(openSpan, err)
 	if err != nil {
 		return fmt.Errorf("failed to open XLSX file: %w", err)
 	}
 	defer xlsx.Close()
 
-	ctx := context.Background()
-	p := postgres.Init(ctx)
-	defer p.Close()
+	schema := config.SchemaFor(file)
 
-	schema := strings.ReplaceAll(file, " ", "_")
+	if config.Lock.Scope == cfg.LockScopeFile {
+		unlock, skipped, err := lockFile(ctx, db, config.Lock, schema)
+		if err != nil {
+			return err
+		}
+		if skipped != "" {
+			result.Skipped = skipped
+			logger(ctx).Warn("file skipped", "reason", skipped)
+			return nil
+		}
+		defer unlock()
+	}
 
-	if err := createSchema(p.Pool, schema); err != nil {
+	err = db.Retry(ctx, "create schema", func(ctx context.Context) error {
+		return createSchema(ctx, db.Pool, schema)
+	})
+	if err != nil {
 		return err
 	}
+	defer func() {
+		recordLoad(ctx, db.Pool, schema, result, err)
+	}()
+	lin := newLineage(config, file, result.LoadID)
 
-	sheets := xlsx.GetSheetMap()
-	for _, sheetName := range sheets {
+	var sheets []string
+	for _, sheetName := range xlsx.GetSheetList() {
 		if sheetName == "" {
 			continue
 		}
+		if contains(reservedSheets, sheetName) {
+			logger(ctx).Warn("sheet skipped, its name is reserved for a system table", "sheet", sheetName)
+			continue
+		}
 		if contains(config.IgnorantSheets, sheetName) {
-			log.Printf("sheet %s in ignorant list", sheetName)
+			logger(ctx).Info("sheet in ignorant list", "sheet", sheetName)
 			continue
 		}
-		createAndInsert(ctx, p.Pool, xlsx, sheetName, schema)
+		sheets = append(sheets, sheetName)
 	}
-	return nil
-}
 
-func createSchema(conn *pgxpool.Pool, schema string) error {
-	ctx := context.Background()
+	progress := newProgressTracker(ctx, file, len(sheets))
+	book := &workbook{f: xlsx}
+	key := historyKey(config)
+	workers := sheetWorkers(config.SheetWorkers, db.Pool)
+	logger(ctx).Debug("loading sheets", "sheets", len(sheets), "workers", workers)
 
+	// Sheets go to tables of their own: they are loaded in parallel and one
+	// failing sheet does not stop the others.
+	results := make([]SheetResult, len(sheets))
+	errs := make([]error, len(sheets))
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, sheetName := range sheets {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, sheetName string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			// Advisory locks also keep the loads of this process apart, and
+			// honour the skip policy.
+			if config.Lock.Scope == cfg.LockScopeNone {
+				unlock := lockTable(schema, sheetName)
+				defer unlock()
+			}
+
+			sheet := SheetResult{Sheet: sheetName}
+			err := recovered(ctx, func() error {
+				return createAndInsert(ctx, db, book, ruleSet, lin, key, config.Lock, file, sheetName, schema, &sheet, i+1, progress)
+			})
+			if err != nil {
+				// Nothing of a failed sheet was committed, but one that
+				// panicked did not reset its counts itself.
+				sheet.Inserted, sheet.Updated, sheet.Closed = 0, 0, 0
+				sheet.Error = err.Error()
+				errs[i] = fmt.Errorf("sheet %s: %w", sheetName, err)
+				if ctx.Err() == nil {
+					logger(ctx).Error("sheet failed", "sheet", sheetName, "error", err)
+				}
+			}
+			results[i] = sheet
+			metrics.AddRows(schema, sheetName, sheet.Inserted, sheet.Updated, sheet.Failed)
+		}(i, sheetName)
+	}
+	wg.Wait()
+
+	for i, sheet := range results {
+		// Sheets not started because ctx was cancelled have no result.
+		if sheet.Sheet == "" {
+			continue
+		}
+		result.addSheet(results[i])
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+func createSchema(ctx context.Context, conn *pgxpool.Pool, schema string) error {
 	var exists bool
 	query := "SELECT EXISTS (SELECT 1 FROM information_schema.schemata WHERE schema_name = $1);"
 	err := conn.QueryRow(ctx, query, schema).Scan(&exists)
 	if err != nil {
-		log.Printf("error check schema exist %s: %v", schema, err)
-		return err
+		return fmt.Errorf("failed to check schema %s: %w", schema, err)
 	}
 
 	if !exists {
@@ -62,37 +205,250 @@ func createSchema(conn *pgxpool.Pool, schema string) error {
 		if err != nil {
 			return fmt.Errorf("error on create schema %s: %v", schema, err)
 		}
-		log.Printf("schema %s created", schema)
+		logger(ctx).Info("schema created", "schema", schema)
 	} else {
-		log.Printf("schema %s already exists", schema)
+		logger(ctx).Debug("schema already exists", "schema", schema)
 	}
 	return nil
 }
 
-func createAndInsert(ctx context.Context, dbPool *pgxpool.Pool, xlsx *excelize.File, sheetName, schema string) {
-	rows, err := xlsx.GetRows(sheetName)
+// traceBatchSize is the number of rows covered by one "batch" span, which
+// groups the SQL spans of a sheet.
+const traceBatchSize = 1000
+
+// detectColumnTypes returns a type for every header column. DetectColumnTypes
+// sizes its result by the first data row, so the columns that row lacks are
+// TEXT.
+func detectColumnTypes(headerRow []string, dataRows [][]string) []string {
+	columnTypes := datatype.DetectColumnTypes(dataRows)
+	for len(columnTypes) < len(headerRow) {
+		columnTypes = append(columnTypes, "TEXT")
+	}
+	return columnTypes
+}
+
+func createAndInsert(ctx context.Context, db *postgres.PgStorage, book *workbook, ruleSet *rules.Set, lin *lineage, key []string, locking cfg.LockConfig, file, sheetName, schema string, sheet *SheetResult, sheetIndex int, progress *progressTracker) (err error) {
+	ctx, span := tracing.Start(ctx, "sheet", attribute.String("sheet", sheetName))
+	defer func() {
+		span.SetAttributes(attribute.Int("rows", sheet.Rows), attribute.Int("failed", sheet.Failed))
+		tracing.End(span, err)
+	}()
+
+	ctx = WithLogger(ctx, logger(ctx).With("sheet", sheetName))
+
+	_, readSpan := tracing.Start(ctx, "excelize.GetRows")
+	rows, err := book.rows(sheetName)
+	tracing.End(readSpan, err)
 	if err != nil {
-		log.Printf("error while get rows from xlsx file sheet: %s err: %v ", sheetName, err)
+		logger(ctx).Error("failed to read sheet rows", "error", err)
 	}
 	if len(rows) < 2 {
-		log.Printf("sheet %s is empty or has an invalid header row", sheetName)
-		return
+		logger(ctx).Warn("sheet is empty or has an invalid header row")
+		return nil
 	}
 
 	headerRow := rows[0]
 	dataRows := rows[1:]
 
-	columnTypes := datatype.DetectColumnTypes(dataRows)
+	validator, err := ruleSet.ForSheet(sheetName, headerRow)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		if err := checkHistoryHeader(sheetName, key, headerRow); err != nil {
+			return err
+		}
+	}
+
+	_, detectSpan := tracing.Start(ctx, "DetectColumnTypes")
+	columnTypes := detectColumnTypes(headerRow, dataRows)
+	detectSpan.End()
+	sheet.Rows = len(dataRows)
+	if lastCell, err := excelize.CoordinatesToCellName(max(len(headerRow), 1), len(rows)); err == nil {
+		sheet.Range = "A1:" + lastCell
+	}
+	sheetProgress := progress.startSheet(sheetName, sheetIndex, len(dataRows))
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for sheet %s: %w", sheetName, err)
+	}
+	// Rolling back with a fresh context lets the rollback reach the server even
+	// when ctx is already cancelled; after Commit it is a no-op.
+	defer tx.Rollback(context.Background())
+	defer func() {
+		// Nothing written by a rolled back sheet is left in the table.
+		if err != nil {
+			sheet.Inserted, sheet.Updated, sheet.Closed = 0, 0, 0
+		}
+	}()
 
-	createTable(ctx, dbPool, schema, sheetName, headerRow, columnTypes)
+	if locking.Scope == "" || locking.Scope == cfg.LockScopeTable {
+		release, skipped, err := advisoryLock(ctx, tx, locking, schema, sheetName)
+		if err != nil {
+			return err
+		}
+		if skipped != "" {
+			sheet.Skipped = skipped
+			logger(ctx).Warn("sheet skipped", "reason", skipped)
+			return nil
+		}
+		// The lock goes with the transaction.
+		defer release()
+	}
+
+	if isSyncSheet(headerRow) {
+		if key != nil {
+			return fmt.Errorf("sheet %s was exported for sync, which history mode does not support", sheetName)
+		}
+		err = syncSheet(ctx, tx, validator, lin, schema, sheetName, headerRow, columnTypes, dataRows, sheet, sheetProgress)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to commit sheet %s: %w", sheetName, err)
+		}
+		logger(ctx).Info("sheet synced", "inserted", sheet.Inserted, "updated", sheet.Updated,
+			"conflicts", sheet.Conflicts, "failed", sheet.Failed)
+		return nil
+	}
+
+	if key != nil {
+		err = createTableFrom(ctx, tx, sheetName, historyTableSQL(schema, sheetName, headerRow, columnTypes))
+	} else {
+		err = createTable(ctx, tx, schema, sheetName, headerRow, columnTypes)
+	}
+	if err != nil {
+		return err
+	}
+	if err := lin.addColumns(ctx, tx, schema, sheetName); err != nil {
+		return err
+	}
+	var history *historyLoader
+	if key != nil {
+		history, err = newHistoryLoader(ctx, tx, schema, sheetName, key, headerRow, lin.loadedAt)
+		if err != nil {
+			return err
+		}
+	}
+	if err := createRejectsTable(ctx, tx, schema); err != nil {
+		return err
+	}
+	if err := clearRejects(ctx, tx, schema, file, sheetName); err != nil {
+		return err
+	}
+	id, _ := loadID(ctx)
+
+	var batchCtx context.Context
+	var batch trace.Span
+	defer func() {
+		if batch != nil {
+			batch.End()
+		}
+	}()
 
 	for rowIndex, row := range dataRows {
-		insertRow(ctx, dbPool, sheetName, headerRow, row, rowIndex+1, schema, columnTypes)
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("sheet %s rolled back: %w", sheetName, err)
+		}
+		if rowIndex%traceBatchSize == 0 {
+			if batch != nil {
+				batch.End()
+			}
+			batchCtx, batch = tracing.Start(ctx, "batch",
+				attribute.Int("first_row", rowIndex+2),
+				attribute.Int("rows", min(traceBatchSize, len(dataRows)-rowIndex)))
+		}
+		if !validator.Empty() {
+			// Data rows start below the header, on Excel row 2.
+			reject, err := validateRow(batchCtx, validator, sheet, rowIndex+2, row)
+			if err != nil {
+				return err
+			}
+			if reject != nil {
+				sheet.Failed++
+				sheet.reject(*reject)
+				quarantine(batchCtx, tx, schema, id, file, *reject, headerRow, row)
+				sheetProgress.row(true)
+				continue
+			}
+		}
+		outcome, err := writeRow(batchCtx, tx, history, lin, schema, sheetName, headerRow, row, columnTypes, rowIndex)
+		switch {
+		case err != nil:
+			sheet.Failed++
+			// Data rows start below the header, on Excel row 2.
+			reject := newReject(sheetName, rowIndex+2, headerRow, columnTypes, row, err)
+			sheet.reject(reject)
+			quarantine(batchCtx, tx, schema, id, file, reject, headerRow, row)
+		case outcome == rowInserted:
+			sheet.Inserted++
+		case outcome == rowUpdated:
+			sheet.Updated++
+		default:
+			sheet.Unchanged++
+		}
+		sheetProgress.row(err != nil)
+	}
+
+	if history != nil {
+		if sheet.Failed > 0 {
+			// A failed row would look like a key removed from the sheet.
+			logger(ctx).Warn("keys missing from the sheet are kept current because rows failed")
+		} else if sheet.Closed, err = history.closeMissing(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit sheet %s: %w", sheetName, err)
 	}
-	log.Printf("Data inserted or updated in table %s successfully", sheetName)
+	logger(ctx).Info("sheet loaded",
+		"inserted", sheet.Inserted, "updated", sheet.Updated, "failed", sheet.Failed)
+	return nil
+}
+
+// validateRow records the rule violations of a row. It returns the reject of
+// a row that must not be loaded, or an error when the sheet must fail.
+func validateRow(ctx context.Context, validator *rules.Sheet, sheet *SheetResult, excelRow int, row []string) (*Reject, error) {
+	var reject *Reject
+	for _, v := range validator.Check(excelRow, row) {
+		sheet.violation(v)
+		switch v.Severity {
+		case rules.SeverityFailSheet:
+			return nil, fmt.Errorf("sheet %s failed rule %q on row %d: %s", v.Sheet, v.Rule, v.Row, v.Message)
+		case rules.SeverityReject:
+			if reject == nil {
+				reject = &Reject{
+					Sheet:   v.Sheet,
+					Row:     v.Row,
+					Column:  v.Column,
+					Value:   v.Value,
+					Code:    "validation",
+					Message: v.Rule + ": " + v.Message,
+				}
+			}
+		default:
+			logger(ctx).Warn("rule violated", "row", v.Row, "rule", v.Rule, "column", v.Column, "message", v.Message)
+		}
+	}
+	return reject, nil
+}
+
+func createTable(ctx context.Context, tx pgx.Tx, schema, sheetName string, columns, columnTypes []string) error {
+	return createTableFrom(ctx, tx, sheetName, createTableSQL(schema, sheetName, columns, columnTypes))
 }
 
-func createTable(ctx context.Context, dbPool *pgxpool.Pool, schema, sheetName string, columns, columnTypes []string) {
+func createTableFrom(ctx context.Context, tx pgx.Tx, sheetName, query string) error {
+	_, err := tx.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", sheetName, err)
+	}
+	logger(ctx).Debug("table ensured", "table", sheetName)
+	return nil
+}
+
+func createTableSQL(schema, sheetName string, columns, columnTypes []string) string {
 	var schemaBuilder strings.Builder
 	schemaBuilder.WriteString(fmt.Sprintf(
 		"CREATE TABLE IF NOT EXISTS %s.%s (\n",
@@ -112,15 +468,27 @@ func createTable(ctx context.Context, dbPool *pgxpool.Pool, schema, sheetName st
 		}
 	}
 
-	schemaSQL := strings.TrimSuffix(schemaBuilder.String(), ",\n") + ");"
-	_, err := dbPool.Exec(ctx, schemaSQL)
-	if err != nil {
-		log.Fatalf("failed to create table %s: %v", sheetName, err)
+	return strings.TrimSuffix(schemaBuilder.String(), ",\n") + ");"
+}
+
+// cellValue converts a cell to the value inserted into a column of the given
+// type. Dates that cannot be converted are passed on as they are.
+func cellValue(ctx context.Context, column, value, columnType string, rowIndex int) string {
+	if columnType == "DATE" && strings.TrimSpace(value) != "" {
+		converted, err := datatype.ConvertToDate(value)
+		if err != nil {
+			logger(ctx).Warn("failed to convert date value", "row", rowIndex+1, "column", column, "value", value, "error", err)
+			return value
+		}
+		return converted
 	}
-	log.Printf("Table %s created successfully", sheetName)
+	return value
 }
 
-func insertRow(ctx context.Context, dbPool *pgxpool.Pool, tableName string, columns, row []string, rowIndex int, schema string, columnTypes []string) {
+// insertRow upserts a single row inside a savepoint, so a failing row does not
+// abort the transaction of the whole sheet. It reports whether the row was
+// newly inserted rather than updated.
+func insertRow(ctx context.Context, tx pgx.Tx, tableName string, columns, row []string, rowIndex int, schema string, columnTypes []string) (bool, error) {
 	insertValues := make([]interface{}, 0)
 	insertValues = append(insertValues, rowIndex)
 	placeholders := []string{"$1"}
@@ -137,22 +505,12 @@ func insertRow(ctx context.Context, dbPool *pgxpool.Pool, tableName string, colu
 		if column == "" {
 			continue
 		}
-		value := row[i]
-
-		if columnTypes[i] == "DATE" && strings.TrimSpace(value) != "" {
-			converted, err := datatype.ConvertToDate(value)
-			if err != nil {
-				log.Printf("failed to convert date value '%s' in column %s: %v", value, column, err)
-			} else {
-				value = converted
-			}
-		}
-		insertValues = append(insertValues, value)
+		insertValues = append(insertValues, cellValue(ctx, column, row[i], columnTypes[i], rowIndex))
 		placeholders = append(placeholders, fmt.Sprintf("$%d", len(insertValues)))
 	}
 
 	insertQuery := fmt.Sprintf(
-		"INSERT INTO %s.%s (id_row, %s) VALUES (%s) ON CONFLICT (id_row) DO UPDATE SET %s",
+		"INSERT INTO %s.%s (id_row, %s) VALUES (%s) ON CONFLICT (id_row) DO UPDATE SET %s RETURNING (xmax = 0)",
 		pq.QuoteIdentifier(schema),
 		pq.QuoteIdentifier(tableName),
 		strings.Join(quoteIdentifiers(columns), ", "),
@@ -160,14 +518,48 @@ func insertRow(ctx context.Context, dbPool *pgxpool.Pool, tableName string, colu
 		buildUpdateSetClause(columns),
 	)
 
-	_, err := dbPool.Exec(ctx, insertQuery, insertValues...)
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		logger(ctx).Error("failed to create savepoint", "row", rowIndex+1, "error", err)
+		return false, err
+	}
+	var inserted bool
+	err = savepoint.QueryRow(ctx, insertQuery, insertValues...).Scan(&inserted)
 	if err != nil {
-		log.Printf("error while inserting row %d in table %s: %v", rowIndex, tableName, err)
-		if pqErr, ok := err.(*pq.Error); ok {
-			log.Printf("PostgreSQL error: %s", pqErr.Code)
-			adjustColumnType(ctx, dbPool, schema, tableName, columns, columnTypes, row)
+		savepoint.Rollback(ctx)
+		logger(ctx).Warn("failed to insert row", "row", rowIndex+1, "error", err)
+		if isDataError(err) {
+			adjustColumnType(ctx, tx, schema, tableName, columns, columnTypes, row)
+		}
+		return false, err
+	}
+	if err := savepoint.Commit(ctx); err != nil {
+		logger(ctx).Error("failed to release savepoint", "row", rowIndex+1, "error", err)
+		return false, err
+	}
+	return inserted, nil
+}
+
+// writeRow loads a data row with its lineage columns. The types of the
+// columns widened for a failing row are kept in columnTypes, so that later
+// rows do not alter them again.
+func writeRow(ctx context.Context, tx pgx.Tx, history *historyLoader, lin *lineage, schema, sheetName string, headerRow, row, columnTypes []string, rowIndex int) (rowOutcome, error) {
+	columns, values, types := lin.extend(sheetName, rowIndex+2, headerRow, row, columnTypes)
+	var outcome rowOutcome
+	var err error
+	if history != nil {
+		outcome, err = history.loadRow(ctx, columns, values, rowIndex, types)
+	} else {
+		var inserted bool
+		inserted, err = insertRow(ctx, tx, sheetName, columns, values, rowIndex+1, schema, types)
+		outcome = rowUpdated
+		if inserted {
+			outcome = rowInserted
 		}
 	}
+	// extend copies the types when it adds lineage columns.
+	copy(columnTypes, types)
+	return outcome, err
 }
 
 func buildUpdateSetClause(columns []string) string {
@@ -178,9 +570,9 @@ func buildUpdateSetClause(columns []string) string {
 	return strings.Join(sets, ", ")
 }
 
-func adjustColumnType(ctx context.Context, dbPool *pgxpool.Pool, schema, tableName string, columns, columnTypes, row []string) {
+func adjustColumnType(ctx context.Context, tx pgx.Tx, schema, tableName string, columns, columnTypes, row []string) {
 	for i, column := range columns {
-		if column == "" {
+		if column == "" || isLineageColumn(column) {
 			continue
 		}
 		newType := datatype.DetermineType(row[i])
@@ -194,11 +586,16 @@ func adjustColumnType(ctx context.Context, dbPool *pgxpool.Pool, schema, tableNa
 				pq.QuoteIdentifier(column),
 				newType,
 			)
-			_, err := dbPool.Exec(ctx, alterQuery)
+			// A failing ALTER must not abort the transaction of the sheet.
+			err := pgx.BeginFunc(ctx, tx, func(savepoint pgx.Tx) error {
+				_, err := savepoint.Exec(ctx, alterQuery)
+				return err
+			})
 			if err != nil {
-				log.Printf("failed to alter column %s in table %s: %v", column, tableName, err)
+				logger(ctx).Error("failed to alter column", "column", column, "error", err)
 			} else {
-				log.Printf("Column %s in table %s changed to %s", column, tableName, newType)
+				logger(ctx).Info("column type changed", "column", column, "type", newType)
+				metrics.TypeAlterations.WithLabelValues(schema, tableName).Inc()
 				columnTypes[i] = newType
 			}
 		}
@@ -0,0 +1,137 @@
+package processXlsx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	cfg "xlsxtoSQL/config"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeTx is a transaction whose savepoints are itself. Exec records the
+// statements it runs and their arguments, and reports affected rows; QueryRow
+// answers with rows, in order, then with err, and Query with the result sets
+// of results, in order. With block set, Exec waits for its context to end.
+type fakeTx struct {
+	pgx.Tx
+	execs    []string
+	args     [][]interface{}
+	affected int
+	rows     []fakeRow
+	results  [][]fakeRow
+	err      error
+	block    bool
+}
+
+type fakeRow struct {
+	values []interface{}
+	err    error
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	for i, v := range r.values {
+		switch d := dest[i].(type) {
+		case *bool:
+			*d = v.(bool)
+		case *int:
+			*d = v.(int)
+		case *int64:
+			*d = v.(int64)
+		case *string:
+			*d = v.(string)
+		case **string:
+			s, _ := v.(*string)
+			*d = s
+		}
+	}
+	return nil
+}
+
+func (tx *fakeTx) Begin(ctx context.Context) (pgx.Tx, error) { return tx, nil }
+func (tx *fakeTx) Commit(ctx context.Context) error          { return nil }
+func (tx *fakeTx) Rollback(ctx context.Context) error        { return nil }
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tx.execs = append(tx.execs, sql)
+	tx.args = append(tx.args, args)
+	if tx.block {
+		<-ctx.Done()
+		return pgconn.CommandTag{}, ctx.Err()
+	}
+	return pgconn.NewCommandTag(fmt.Sprintf("UPDATE %d", tx.affected)), nil
+}
+
+func (tx *fakeTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	if len(tx.rows) == 0 {
+		return fakeRow{err: tx.err}
+	}
+	row := tx.rows[0]
+	tx.rows = tx.rows[1:]
+	return row
+}
+
+func (tx *fakeTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if len(tx.results) == 0 {
+		return &fakeRows{}, nil
+	}
+	rows := tx.results[0]
+	tx.results = tx.results[1:]
+	return &fakeRows{rows: rows}, nil
+}
+
+// fakeRows iterates over a result set of fakeTx.
+type fakeRows struct {
+	pgx.Rows
+	rows    []fakeRow
+	current fakeRow
+}
+
+func (r *fakeRows) Next() bool {
+	if len(r.rows) == 0 {
+		return false
+	}
+	r.current, r.rows = r.rows[0], r.rows[1:]
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error { return r.current.Scan(dest...) }
+func (r *fakeRows) Close()                         {}
+func (r *fakeRows) Err() error                     { return nil }
+
+func (tx *fakeTx) alters() int {
+	n := 0
+	for _, sql := range tx.execs {
+		if strings.HasPrefix(sql, "ALTER TABLE") {
+			n++
+		}
+	}
+	return n
+}
+
+func Test_writeRowWidensColumnTypes(t *testing.T) {
+	ctx := context.Background()
+	for _, lineageColumns := range [][]string{nil, {"_source_row", "_load_id"}} {
+		tx := &fakeTx{err: &pgconn.PgError{Code: "22P02", Message: "invalid input syntax for type integer"}}
+		lin := newLineage(cfg.Config{LineageColumns: lineageColumns}, "book.xlsx", "load")
+		header := []string{"id", "amount"}
+		columnTypes := []string{"INTEGER", "INTEGER"}
+
+		for rowIndex, row := range [][]string{{"1", "2.5"}, {"2", "3.5"}} {
+			if _, err := writeRow(ctx, tx, nil, lin, "s", "Sheet1", header, row, columnTypes, rowIndex); err == nil {
+				t.Fatalf("lineage %q: writeRow() of row %d succeeded, want the insert error", lineageColumns, rowIndex)
+			}
+		}
+		if columnTypes[1] != "FLOAT" {
+			t.Errorf("lineage %q: column types after the ALTER = %q, want amount FLOAT", lineageColumns, columnTypes)
+		}
+		if n := tx.alters(); n != 1 {
+			t.Errorf("lineage %q: %d ALTERs for two rows with the same value types, want 1: %q", lineageColumns, n, tx.execs)
+		}
+	}
+}
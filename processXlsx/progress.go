@@ -0,0 +1,113 @@
+package processXlsx
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// progressInterval limits how often row progress is reported.
+const progressInterval = 500 * time.Millisecond
+
+// Progress is a snapshot of how far the loader has got in the current file.
+type Progress struct {
+	File          string  `json:"file"`
+	Sheet         string  `json:"sheet"`
+	SheetIndex    int     `json:"sheet_index"`
+	SheetCount    int     `json:"sheet_count"`
+	RowsProcessed int     `json:"rows_processed"`
+	RowsTotal     int     `json:"rows_total"`
+	RowsFailed    int     `json:"rows_failed"`
+	RowsPerSecond float64 `json:"rows_per_second"`
+	ETASeconds    float64 `json:"eta_seconds"`
+	// FileRowsDone counts the rows processed in the other sheets of the
+	// file, not those of Sheet: with sheets loaded one after another, the
+	// rows of the finished sheets.
+	FileRowsDone int       `json:"file_rows_done"`
+	StartedAt    time.Time `json:"started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type progressKey struct{}
+
+// WithProgress registers a function receiving progress events of the loader.
+// It is called from the loading goroutines, one at a time, and must not
+// block.
+func WithProgress(ctx context.Context, report func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// progressTracker turns row level events into throttled Progress reports.
+// Sheets loaded in parallel report through their own sheetProgress; each
+// report describes the sheet whose row triggered it.
+type progressTracker struct {
+	mu         sync.Mutex
+	report     func(Progress)
+	file       string
+	sheetCount int
+	startedAt  time.Time
+	// rowsDone counts the rows processed in every sheet of the file.
+	rowsDone   int
+	lastReport time.Time
+}
+
+// sheetProgress is the progress of one sheet.
+type sheetProgress struct {
+	t     *progressTracker
+	p     Progress
+	start time.Time
+}
+
+func newProgressTracker(ctx context.Context, file string, sheetCount int) *progressTracker {
+	report, _ := ctx.Value(progressKey{}).(func(Progress))
+	return &progressTracker{report: report, file: file, sheetCount: sheetCount, startedAt: time.Now()}
+}
+
+func (t *progressTracker) startSheet(sheet string, index, rowsTotal int) *sheetProgress {
+	s := &sheetProgress{
+		t: t,
+		p: Progress{
+			File:       t.file,
+			Sheet:      sheet,
+			SheetIndex: index,
+			SheetCount: t.sheetCount,
+			RowsTotal:  rowsTotal,
+			StartedAt:  t.startedAt,
+		},
+		start: time.Now(),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emit(s)
+	return s
+}
+
+func (s *sheetProgress) row(failed bool) {
+	t := s.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.p.RowsProcessed++
+	t.rowsDone++
+	if failed {
+		s.p.RowsFailed++
+	}
+	if time.Since(t.lastReport) >= progressInterval || s.p.RowsProcessed == s.p.RowsTotal {
+		t.emit(s)
+	}
+}
+
+// emit reports the progress of s. t.mu must be held.
+func (t *progressTracker) emit(s *sheetProgress) {
+	if t.report == nil {
+		return
+	}
+	now := time.Now()
+	if elapsed := now.Sub(s.start).Seconds(); elapsed > 0 && s.p.RowsProcessed > 0 {
+		s.p.RowsPerSecond = float64(s.p.RowsProcessed) / elapsed
+		s.p.ETASeconds = float64(s.p.RowsTotal-s.p.RowsProcessed) / s.p.RowsPerSecond
+	}
+	s.p.FileRowsDone = t.rowsDone - s.p.RowsProcessed
+	s.p.UpdatedAt = now
+	t.lastReport = now
+	t.report(s.p)
+}
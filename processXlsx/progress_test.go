@@ -0,0 +1,71 @@
+package processXlsx
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_progressTracker(t *testing.T) {
+	var reports []Progress
+	ctx := WithProgress(context.Background(), func(p Progress) { reports = append(reports, p) })
+	tracker := newProgressTracker(ctx, "book.xlsx", 2)
+
+	first := tracker.startSheet("A", 1, 3)
+	first.row(false)
+	first.row(true)
+	first.row(false)
+	second := tracker.startSheet("B", 2, 2)
+	second.row(false)
+	second.row(false)
+
+	// Rows within progressInterval of the last report are not reported, the
+	// last row of a sheet always is.
+	want := []struct {
+		sheet                                  string
+		processed, failed, fileRowsDone, total int
+	}{
+		{"A", 0, 0, 0, 3},
+		{"A", 3, 1, 0, 3},
+		{"B", 0, 0, 3, 2},
+		{"B", 2, 0, 3, 2},
+	}
+	if len(reports) != len(want) {
+		t.Fatalf("got %d reports, want %d: %+v", len(reports), len(want), reports)
+	}
+	for i, w := range want {
+		p := reports[i]
+		if p.File != "book.xlsx" || p.SheetCount != 2 || p.Sheet != w.sheet || p.RowsProcessed != w.processed ||
+			p.RowsFailed != w.failed || p.FileRowsDone != w.fileRowsDone || p.RowsTotal != w.total {
+			t.Errorf("report %d = %+v, want %+v", i, p, w)
+		}
+	}
+	if p := reports[1]; p.RowsPerSecond <= 0 || p.ETASeconds != 0 {
+		t.Errorf("finished sheet reports %v rows/s and an ETA of %vs, want a rate and no ETA", p.RowsPerSecond, p.ETASeconds)
+	}
+}
+
+func Test_progressTrackerParallel(t *testing.T) {
+	var last Progress
+	ctx := WithProgress(context.Background(), func(p Progress) { last = p })
+	tracker := newProgressTracker(ctx, "book.xlsx", 2)
+
+	a := tracker.startSheet("A", 1, 10)
+	b := tracker.startSheet("B", 2, 1)
+	a.row(false)
+	a.row(false)
+	b.row(false)
+
+	// The rows of A still loading count for B.
+	if last.Sheet != "B" || last.RowsProcessed != 1 || last.FileRowsDone != 2 {
+		t.Errorf("last report = %+v, want sheet B with 1 row and 2 rows done elsewhere", last)
+	}
+}
+
+func Test_progressTrackerWithoutReport(t *testing.T) {
+	tracker := newProgressTracker(context.Background(), "book.xlsx", 1)
+	s := tracker.startSheet("A", 1, 1)
+	s.row(false)
+	if tracker.rowsDone != 1 {
+		t.Errorf("rowsDone = %d, want 1", tracker.rowsDone)
+	}
+}
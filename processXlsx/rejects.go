@@ -0,0 +1,367 @@
+package processXlsx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	cfg "xlsxtoSQL/config"
+	"xlsxtoSQL/postgres"
+	"xlsxtoSQL/rules"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+)
+
+// rejectsTable quarantines the rows of a workbook that failed to load. It
+// lives in the schema of the workbook next to the sheet tables.
+const rejectsTable = "_rejects"
+
+func createRejectsTable(ctx context.Context, tx pgx.Tx, schema string) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+id BIGSERIAL PRIMARY KEY,
+load_id TEXT NOT NULL,
+source_file TEXT NOT NULL,
+sheet TEXT NOT NULL,
+row_number INTEGER NOT NULL,
+data JSONB NOT NULL,
+column_name TEXT,
+error_code TEXT,
+error TEXT NOT NULL,
+attempts INTEGER NOT NULL DEFAULT 0,
+created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+last_attempt_at TIMESTAMPTZ
+);`, pq.QuoteIdentifier(schema), pq.QuoteIdentifier(rejectsTable))
+	if _, err := tx.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to create rejects table: %w", err)
+	}
+	return nil
+}
+
+// clearRejects drops the quarantined rows of an earlier load of the sheet:
+// every load reads the whole sheet again, so its own rejects replace them.
+func clearRejects(ctx context.Context, tx pgx.Tx, schema, file, sheet string) error {
+	query := fmt.Sprintf("DELETE FROM %s.%s WHERE source_file = $1 AND sheet = $2",
+		pq.QuoteIdentifier(schema), pq.QuoteIdentifier(rejectsTable))
+	if _, err := tx.Exec(ctx, query, file, sheet); err != nil {
+		return fmt.Errorf("failed to clear rejects of sheet %s: %w", sheet, err)
+	}
+	return nil
+}
+
+// quarantine stores a failed row with its raw cell values keyed by column.
+// It runs in a savepoint so a failure only loses the reject, not the sheet.
+func quarantine(ctx context.Context, tx pgx.Tx, schema, loadID, file string, reject Reject, columns, row []string) {
+	data, err := json.Marshal(rowValues(columns, row))
+	if err != nil {
+		logger(ctx).Error("failed to encode rejected row", "row", reject.Row, "error", err)
+		return
+	}
+	query := fmt.Sprintf(`INSERT INTO %s.%s (load_id, source_file, sheet, row_number, data, column_name, error_code, error)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8)`,
+		pq.QuoteIdentifier(schema), pq.QuoteIdentifier(rejectsTable))
+	err = pgx.BeginFunc(ctx, tx, func(savepoint pgx.Tx) error {
+		_, err := savepoint.Exec(ctx, query, loadID, file, reject.Sheet, reject.Row, data,
+			reject.Column, reject.Code, reject.Message)
+		return err
+	})
+	if err != nil {
+		logger(ctx).Error("failed to quarantine rejected row", "row", reject.Row, "error", err)
+	}
+}
+
+func rowValues(columns, row []string) map[string]string {
+	values := make(map[string]string, len(columns))
+	for i, column := range columns {
+		if column == "" {
+			continue
+		}
+		if i < len(row) {
+			values[column] = row[i]
+		} else {
+			values[column] = ""
+		}
+	}
+	return values
+}
+
+// RetryResult is the outcome of re-attempting the quarantined rows of a
+// workbook.
+type RetryResult struct {
+	File    string   `json:"file"`
+	Retried int      `json:"retried"`
+	Loaded  int      `json:"loaded"`
+	Failed  int      `json:"failed"`
+	Rejects []Reject `json:"rejects,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type quarantined struct {
+	id     int64
+	sheet  string
+	row    int
+	values map[string]string
+}
+
+// RetryRejects loads the quarantined rows of file again, typically after the
+// column type or the data was fixed. Rows that load are removed from the
+// rejects table; the others keep it with their new error and attempt count.
+// The validation rules of config apply again, a failing sheet rule aborting
+// the whole retry.
+// An empty sheet retries the rows of every sheet.
+func RetryRejects(ctx context.Context, config cfg.Config, file, sheet string) (RetryResult, error) {
+	result := RetryResult{File: file}
+	err := retryRejects(ctx, config, file, sheet, &result)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, err
+}
+
+func retryRejects(ctx context.Context, config cfg.Config, file, sheet string, result *RetryResult) error {
+	ctx = WithLogger(ctx, logger(ctx).With("file", file))
+	schema := config.SchemaFor(file)
+
+	p, err := postgres.Open(ctx, config.PostgresURLBaseDB, config.Pool)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	var exists bool
+	err = p.Pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL",
+		pq.QuoteIdentifier(schema)+"."+pq.QuoteIdentifier(rejectsTable)).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up rejects table: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	if config.Lock.Scope == cfg.LockScopeFile {
+		unlock, skipped, err := lockFile(ctx, p, config.Lock, schema)
+		if err != nil {
+			return err
+		}
+		if skipped != "" {
+			return fmt.Errorf("retry skipped: %s", skipped)
+		}
+		defer unlock()
+	}
+
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin retry transaction: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+	if config.Lock.Scope == "" || config.Lock.Scope == cfg.LockScopeTable {
+		release, err := lockRejectTables(ctx, tx, config.Lock, schema, file, sheet)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	query := fmt.Sprintf(`SELECT id, sheet, row_number, data FROM %s.%s
+WHERE source_file = $1 AND ($2 = '' OR sheet = $2) ORDER BY sheet, row_number FOR UPDATE`,
+		pq.QuoteIdentifier(schema), pq.QuoteIdentifier(rejectsTable))
+	rows, err := tx.Query(ctx, query, file, sheet)
+	if err != nil {
+		return fmt.Errorf("failed to read rejects: %w", err)
+	}
+	pending, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (quarantined, error) {
+		var q quarantined
+		err := row.Scan(&q.id, &q.sheet, &q.row, &q.values)
+		return q, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read rejects: %w", err)
+	}
+
+	ruleSet, err := rules.Compile(config.Rules)
+	if err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+	id, ok := loadID(ctx)
+	if !ok {
+		id = newLoadID()
+	}
+	lin := newLineage(config, file, id)
+	key := historyKey(config)
+	histories := map[string]*historyLoader{}
+	columnTypes := map[string]map[string]string{}
+	validators := map[string]*rules.Sheet{}
+	for _, q := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		types, ok := columnTypes[q.sheet]
+		if !ok {
+			if err := lin.addColumns(ctx, tx, schema, q.sheet); err != nil {
+				return err
+			}
+			types, err = tableColumnTypes(ctx, tx, schema, q.sheet)
+			if err != nil {
+				return err
+			}
+			columnTypes[q.sheet] = types
+		}
+
+		columns, row, rowTypes := quarantinedRow(q.values, types)
+
+		rowCtx := WithLogger(ctx, logger(ctx).With("sheet", q.sheet))
+		validator, ok := validators[q.sheet]
+		if !ok {
+			validator, err = ruleSet.ForSheet(q.sheet, columns)
+			if err != nil {
+				return err
+			}
+			validators[q.sheet] = validator
+		}
+
+		result.Retried++
+		var reject *Reject
+		if !validator.Empty() {
+			var sheet SheetResult
+			reject, err = validateRow(rowCtx, validator, &sheet, q.row, row)
+			if err != nil {
+				return err
+			}
+		}
+		if reject == nil {
+			// id_row counts data rows from 1, the header being Excel row 1.
+			extColumns, extRow, extTypes := lin.extend(q.sheet, q.row, columns, row, rowTypes)
+			if key != nil {
+				history, ok := histories[q.sheet]
+				if !ok {
+					history, err = newHistoryLoader(ctx, tx, schema, q.sheet, key, columns, lin.loadedAt)
+					if err != nil {
+						return err
+					}
+					histories[q.sheet] = history
+				}
+				_, err = history.loadRow(rowCtx, extColumns, extRow, q.row-2, extTypes)
+			} else {
+				_, err = insertRow(rowCtx, tx, q.sheet, extColumns, extRow, q.row-1, schema, extTypes)
+			}
+			if err != nil {
+				r := newReject(q.sheet, q.row, columns, rowTypes, row, err)
+				reject = &r
+			}
+		}
+		if reject != nil {
+			result.Failed++
+			if len(result.Rejects) < maxRejectsPerSheet {
+				result.Rejects = append(result.Rejects, *reject)
+			}
+			update := fmt.Sprintf(`UPDATE %s.%s SET attempts = attempts + 1, last_attempt_at = $2,
+column_name = NULLIF($3, ''), error_code = NULLIF($4, ''), error = $5 WHERE id = $1`,
+				pq.QuoteIdentifier(schema), pq.QuoteIdentifier(rejectsTable))
+			if _, err := tx.Exec(ctx, update, q.id, time.Now(), reject.Column, reject.Code, reject.Message); err != nil {
+				return fmt.Errorf("failed to update reject %d: %w", q.id, err)
+			}
+			continue
+		}
+		result.Loaded++
+		remove := fmt.Sprintf("DELETE FROM %s.%s WHERE id = $1",
+			pq.QuoteIdentifier(schema), pq.QuoteIdentifier(rejectsTable))
+		if _, err := tx.Exec(ctx, remove, q.id); err != nil {
+			return fmt.Errorf("failed to remove reject %d: %w", q.id, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit retry: %w", err)
+	}
+	logger(ctx).Info("quarantined rows retried", "retried", result.Retried, "loaded", result.Loaded, "failed", result.Failed)
+	return nil
+}
+
+// quarantinedRow rebuilds a row from the cells stored in _rejects, with the
+// types its table has.
+func quarantinedRow(values, types map[string]string) (columns, row, rowTypes []string) {
+	columns = make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	row = make([]string, len(columns))
+	rowTypes = make([]string, len(columns))
+	for i, column := range columns {
+		row[i] = values[column]
+		rowTypes[i] = types[column]
+	}
+	return columns, row, rowTypes
+}
+
+// lockRejectTables takes the table locks of the sheets whose rejects are
+// retried, before their rows are locked: a load of the sheet deletes them.
+func lockRejectTables(ctx context.Context, tx pgx.Tx, config cfg.LockConfig, schema, file, sheet string) (func(), error) {
+	query := fmt.Sprintf("SELECT DISTINCT sheet FROM %s.%s WHERE source_file = $1 AND ($2 = '' OR sheet = $2) ORDER BY sheet",
+		pq.QuoteIdentifier(schema), pq.QuoteIdentifier(rejectsTable))
+	rows, err := tx.Query(ctx, query, file, sheet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rejects: %w", err)
+	}
+	sheets, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rejects: %w", err)
+	}
+
+	var releases []func()
+	releaseAll := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+	for _, name := range sheets {
+		release, skipped, err := advisoryLock(ctx, tx, config, schema, name)
+		if err == nil && skipped != "" {
+			err = fmt.Errorf("retry skipped: sheet %s: %s", name, skipped)
+		}
+		if err != nil {
+			releaseAll()
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	return releaseAll, nil
+}
+
+// tableColumnTypes maps the columns of a sheet table to the type names used
+// by the datatype package.
+func tableColumnTypes(ctx context.Context, tx pgx.Tx, schema, table string) (map[string]string, error) {
+	rows, err := tx.Query(ctx, `SELECT column_name, data_type FROM information_schema.columns
+WHERE table_schema = $1 AND table_name = $2`, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	types := map[string]string{}
+	for rows.Next() {
+		var column, dataType string
+		if err := rows.Scan(&column, &dataType); err != nil {
+			return nil, err
+		}
+		switch dataType {
+		case "integer", "bigint", "smallint":
+			types[column] = "INTEGER"
+		case "double precision", "real", "numeric":
+			types[column] = "FLOAT"
+		case "date":
+			types[column] = "DATE"
+		default:
+			types[column] = "TEXT"
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(types) == 0 {
+		return nil, errors.New("table " + table + " does not exist")
+	}
+	return types, nil
+}
@@ -0,0 +1,123 @@
+package processXlsx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"xlsxtoSQL/datatype"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// maxRejectsPerSheet caps the rejected rows kept in a report, so a sheet
+// where every row fails does not produce a report larger than the sheet.
+const maxRejectsPerSheet = 1000
+
+// Reject describes a row that could not be loaded.
+type Reject struct {
+	Sheet   string `json:"sheet"`
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// newReject builds the reject of a failed row. excelRow is the 1-based row
+// number as shown in Excel, the header being row 1.
+func newReject(sheet string, excelRow int, columns, columnTypes, row []string, err error) Reject {
+	reject := Reject{Sheet: sheet, Row: excelRow, Message: err.Error()}
+	if errors.Is(err, errDuplicateKey) {
+		reject.Code = "duplicate_key"
+		return reject
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		reject.Code = pgErr.Code
+		reject.Message = pgErr.Message
+		reject.Column = pgErr.ColumnName
+	}
+
+	if reject.Column == "" {
+		reject.Column = offendingColumn(columns, columnTypes, row)
+	}
+	if reject.Column != "" {
+		for i, column := range columns {
+			if column == reject.Column && i < len(row) {
+				reject.Value = row[i]
+				break
+			}
+		}
+	}
+	return reject
+}
+
+// offendingColumn guesses which cell made a row fail: the first non-empty
+// value that does not fit the type detected for its column.
+func offendingColumn(columns, columnTypes, row []string) string {
+	for i, column := range columns {
+		if column == "" || i >= len(row) || i >= len(columnTypes) {
+			continue
+		}
+		value := strings.TrimSpace(row[i])
+		if value == "" {
+			continue
+		}
+		if !fitsType(value, columnTypes[i]) {
+			return column
+		}
+	}
+	return ""
+}
+
+func fitsType(value, columnType string) bool {
+	switch columnType {
+	case "INTEGER":
+		return datatype.DetermineType(value) == "INTEGER"
+	case "FLOAT":
+		t := datatype.DetermineType(value)
+		return t == "INTEGER" || t == "FLOAT"
+	case "DATE":
+		_, err := datatype.ConvertToDate(value)
+		return err == nil
+	default:
+		return true
+	}
+}
+
+// isDataError reports whether err is a PostgreSQL data exception or type
+// mismatch, the errors a column type change can fix.
+func isDataError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return strings.HasPrefix(pgErr.Code, "22") || pgErr.Code == "42804"
+}
+
+// WriteReport stores the run as an indented JSON document in dir and returns
+// the path of the file.
+func (run Run) WriteReport(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create report directory: %w", err)
+	}
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode report: %w", err)
+	}
+	path := filepath.Join(dir, ReportName(run))
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	return path, nil
+}
+
+// ReportName is the file name WriteReport uses for a run. Names sort in the
+// order the runs started.
+func ReportName(run Run) string {
+	return "report-" + run.StartedAt.UTC().Format("20060102T150405.000Z") + ".json"
+}
@@ -0,0 +1,100 @@
+package processXlsx
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func Test_offendingColumn(t *testing.T) {
+	columns := []string{"id", "", "amount", "day", "note"}
+	types := []string{"INTEGER", "TEXT", "FLOAT", "DATE", "TEXT"}
+	tests := []struct {
+		row  []string
+		want string
+	}{
+		{[]string{"1", "x", "2.5", "2024-01-31", "hi"}, ""},
+		{[]string{"one", "x", "2.5"}, "id"},
+		{[]string{"1", "x", "2"}, ""},
+		{[]string{"1", "x", "lots"}, "amount"},
+		{[]string{"1", "x", "", "soon"}, "day"},
+		{[]string{" ", "x", "", ""}, ""},
+		{[]string{"1"}, ""},
+		{[]string{"1.5", "x", "abc"}, "id"},
+	}
+	for _, tt := range tests {
+		if got := offendingColumn(columns, types, tt.row); got != tt.want {
+			t.Errorf("offendingColumn(%q) = %q, want %q", tt.row, got, tt.want)
+		}
+	}
+}
+
+func Test_isDataError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: "22P02"}, true},
+		{&pgconn.PgError{Code: "22003"}, true},
+		{&pgconn.PgError{Code: "42804"}, true},
+		{fmt.Errorf("sheet rolled back: %w", &pgconn.PgError{Code: "22007"}), true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{&pgconn.PgError{Code: "42P01"}, false},
+		{errors.New("invalid input syntax"), false},
+		{errDuplicateKey, false},
+	}
+	for _, tt := range tests {
+		if got := isDataError(tt.err); got != tt.want {
+			t.Errorf("isDataError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func Test_newReject(t *testing.T) {
+	columns := []string{"id", "amount"}
+	types := []string{"INTEGER", "FLOAT"}
+	tests := []struct {
+		name string
+		row  []string
+		err  error
+		want Reject
+	}{
+		{
+			name: "column named by PostgreSQL",
+			row:  []string{"1", "2.5"},
+			err:  &pgconn.PgError{Code: "23502", Message: "null value in column", ColumnName: "amount"},
+			want: Reject{Sheet: "Data", Row: 3, Column: "amount", Value: "2.5", Code: "23502", Message: "null value in column"},
+		},
+		{
+			name: "column guessed from the types",
+			row:  []string{"1", "lots"},
+			err:  &pgconn.PgError{Code: "22P02", Message: "invalid input syntax for type double precision"},
+			want: Reject{Sheet: "Data", Row: 3, Column: "amount", Value: "lots", Code: "22P02", Message: "invalid input syntax for type double precision"},
+		},
+		{
+			name: "column missing from a short row",
+			row:  []string{"one"},
+			err:  &pgconn.PgError{Code: "23502", Message: "null value in column", ColumnName: "amount"},
+			want: Reject{Sheet: "Data", Row: 3, Column: "amount", Code: "23502", Message: "null value in column"},
+		},
+		{
+			name: "duplicate key",
+			row:  []string{"one", "lots"},
+			err:  errDuplicateKey,
+			want: Reject{Sheet: "Data", Row: 3, Code: "duplicate_key", Message: errDuplicateKey.Error()},
+		},
+		{
+			name: "other error",
+			row:  []string{"1", "2"},
+			err:  errors.New("conn closed"),
+			want: Reject{Sheet: "Data", Row: 3, Message: "conn closed"},
+		},
+	}
+	for _, tt := range tests {
+		if got := newReject("Data", 3, columns, types, tt.row, tt.err); got != tt.want {
+			t.Errorf("%s: newReject() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package processXlsx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	cfg "xlsxtoSQL/config"
+	"xlsxtoSQL/postgres"
+	"xlsxtoSQL/rules"
+	"xlsxtoSQL/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SheetResult describes what happened to a single sheet during a run.
+type SheetResult struct {
+	Sheet    string `json:"sheet"`
+	Range    string `json:"range,omitempty"` // loaded cells, header included
+	Rows     int    `json:"rows"`
+	Inserted int    `json:"inserted"`
+	Updated  int    `json:"updated"`
+	// Unchanged counts the rows already in the table as they are, in history
+	// mode and in synced sheets. Closed counts the keys removed from the
+	// sheet in history mode.
+	Unchanged int `json:"unchanged,omitempty"`
+	Closed    int `json:"closed,omitempty"`
+	// Conflicts counts the rows of a synced sheet that were changed in the
+	// database since the export and were left alone.
+	Conflicts int    `json:"conflicts,omitempty"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"`
+	// Skipped tells why the sheet was not loaded: its table was locked by
+	// another load and the lock policy is skip.
+	Skipped string `json:"skipped,omitempty"`
+	// Rejects lists the failed rows, at most maxRejectsPerSheet of them.
+	Rejects          []Reject `json:"rejects,omitempty"`
+	RejectsTruncated bool     `json:"rejects_truncated,omitempty"`
+	// Violations lists the failed validation rules, whatever their severity.
+	Violations          []rules.Violation `json:"violations,omitempty"`
+	ViolationsTruncated bool              `json:"violations_truncated,omitempty"`
+}
+
+func (s *SheetResult) reject(r Reject) {
+	if len(s.Rejects) >= maxRejectsPerSheet {
+		s.RejectsTruncated = true
+		return
+	}
+	s.Rejects = append(s.Rejects, r)
+}
+
+func (s *SheetResult) violation(v rules.Violation) {
+	if len(s.Violations) >= maxRejectsPerSheet {
+		s.ViolationsTruncated = true
+		return
+	}
+	s.Violations = append(s.Violations, v)
+}
+
+// Result is the outcome of loading one workbook.
+type Result struct {
+	File            string        `json:"file"`
+	LoadID          string        `json:"load_id"`
+	Sheets          []SheetResult `json:"sheets"`
+	Inserted        int           `json:"inserted"`
+	Updated         int           `json:"updated"`
+	Failed          int           `json:"failed"`
+	StartedAt       time.Time     `json:"started_at"`
+	FinishedAt      time.Time     `json:"finished_at"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	Error           string        `json:"error,omitempty"`
+	// Skipped tells why the file was not loaded, as SheetResult.Skipped.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+func (r *Result) addSheet(sheet SheetResult) {
+	r.Sheets = append(r.Sheets, sheet)
+	r.Inserted += sheet.Inserted
+	r.Updated += sheet.Updated
+	r.Failed += sheet.Failed
+}
+
+func (r *Result) finish(err error) {
+	r.FinishedAt = time.Now()
+	r.DurationSeconds = r.FinishedAt.Sub(r.StartedAt).Seconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+type loadIDKey struct{}
+
+// WithLoadID sets the ID recorded with everything a load writes. Without one
+// each ProcessExcelFile call gets its own.
+func WithLoadID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, loadIDKey{}, id)
+}
+
+func loadID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(loadIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// newLoadID returns an ID that sorts by start time.
+func newLoadID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// Run is a single pass of a Runner over all of its files.
+type Run struct {
+	Number     int       `json:"number"`
+	LoadID     string    `json:"load_id"`
+	Status     string    `json:"status"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Inserted   int       `json:"inserted"`
+	Updated    int       `json:"updated"`
+	Failed     int       `json:"failed"`
+	Error      string    `json:"error,omitempty"`
+	Results    []Result  `json:"results"`
+}
+
+// Runner loads the files of a config either once or every IntervalSeconds
+// until its context is cancelled. It is safe to read its results while it runs.
+//
+// The runner owns one connection pool, opened by the first run and shared by
+// all of its files and runs until Run returns or Close is called.
+type Runner struct {
+	config cfg.Config
+	once   bool
+
+	dbMu sync.Mutex
+	db   *postgres.PgStorage
+
+	// OnRunDone, if set, is called after every run with its outcome.
+	OnRunDone func(Run)
+
+	mu      sync.Mutex
+	runs    int
+	results []Result
+	// progress holds the latest progress event of each file of the run.
+	progress map[string]Progress
+	locks    map[int64]Lock
+}
+
+func NewRunner(config cfg.Config, once bool) *Runner {
+	return &Runner{config: config, once: once}
+}
+
+// Run processes the configured files until ctx is cancelled, or a single time
+// if the runner was created with once. It returns ctx.Err() when stopped.
+func (r *Runner) Run(ctx context.Context) error {
+	defer r.Close()
+	for {
+		r.RunOnce(ctx)
+		if r.once {
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(r.config.IntervalSeconds) * time.Second):
+		}
+	}
+}
+
+// RunOnce processes every configured file a single time and returns the
+// results. Results of completed files are visible through Results while the
+// run is still in progress.
+func (r *Runner) RunOnce(ctx context.Context) []Result {
+	r.mu.Lock()
+	r.runs++
+	r.results = nil
+	r.progress = map[string]Progress{}
+	run := Run{Number: r.runs, LoadID: newLoadID(), StartedAt: time.Now()}
+	r.mu.Unlock()
+
+	ctx = WithLoadID(ctx, run.LoadID)
+	ctx = WithLogger(ctx, logger(ctx).With("load_id", run.LoadID))
+	ctx, span := tracing.StartLinked(ctx, "run",
+		attribute.Int("number", run.Number), attribute.String("load_id", run.LoadID))
+	defer span.End()
+
+	ctx = WithProgress(ctx, func(p Progress) {
+		r.mu.Lock()
+		r.progress[p.File] = p
+		r.mu.Unlock()
+	})
+	ctx = WithLocks(ctx, func(lock Lock, released bool) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if released {
+			delete(r.locks, lock.ID)
+			return
+		}
+		if r.locks == nil {
+			r.locks = map[int64]Lock{}
+		}
+		r.locks[lock.ID] = lock
+	})
+
+	// Without a database every file fails the same way; the next run tries
+	// to connect again.
+	db, dbErr := r.storage(ctx)
+	if dbErr != nil {
+		logger(ctx).Error("failed to connect to database", "error", dbErr)
+	}
+
+	files := r.config.ExcelFilePaths
+	workers := max(r.config.FileWorkers, 1)
+	results := make([]*Result, len(files))
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, file := range files {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			var result Result
+			var err error
+			if dbErr != nil {
+				result = Result{File: file, LoadID: run.LoadID, StartedAt: time.Now()}
+				err = dbErr
+				result.finish(err)
+			} else {
+				result, err = ProcessExcelFile(ctx, db, r.config, file)
+			}
+			if err != nil {
+				logger(ctx).Error("failed to process workbook", "file", file, "error", err)
+			}
+			results[i] = &result
+			// Results of the run in progress are listed as files finish.
+			r.mu.Lock()
+			r.results = append(r.results, result)
+			r.mu.Unlock()
+		}(i, file)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	r.results = nil
+	for _, result := range results {
+		if result != nil {
+			r.results = append(r.results, *result)
+		}
+	}
+	r.mu.Unlock()
+
+	run.Results = r.Results()
+	run.FinishedAt = time.Now()
+	run.Status = "finished"
+	var errs []error
+	for _, result := range run.Results {
+		run.Inserted += result.Inserted
+		run.Updated += result.Updated
+		run.Failed += result.Failed
+		if result.Error != "" {
+			run.Status = "failed"
+			errs = append(errs, fmt.Errorf("%s: %s", result.File, result.Error))
+		}
+	}
+	if len(errs) > 0 {
+		run.Error = errors.Join(errs...).Error()
+	}
+	if ctx.Err() != nil {
+		run.Status = "stopped"
+	}
+	if r.OnRunDone != nil {
+		r.OnRunDone(run)
+	}
+	return run.Results
+}
+
+// storage returns the connection pool of the runner, opening it if needed.
+func (r *Runner) storage(ctx context.Context) (*postgres.PgStorage, error) {
+	r.dbMu.Lock()
+	defer r.dbMu.Unlock()
+	if r.db == nil {
+		db, err := postgres.Open(ctx, r.config.PostgresURLBaseDB, r.config.Pool)
+		if err != nil {
+			return nil, err
+		}
+		r.db = db
+	}
+	return r.db, nil
+}
+
+// Close closes the connection pool of the runner. A later run opens a new one.
+func (r *Runner) Close() {
+	r.dbMu.Lock()
+	defer r.dbMu.Unlock()
+	if r.db != nil {
+		r.db.Close()
+		r.db = nil
+	}
+}
+
+// Results returns a copy of the results of the current or last run.
+func (r *Runner) Results() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Result(nil), r.results...)
+}
+
+// Progress returns the latest progress event of the current or last run,
+// whichever file it came from.
+func (r *Runner) Progress() (Progress, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var latest Progress
+	for _, p := range r.progress {
+		if p.UpdatedAt.After(latest.UpdatedAt) {
+			latest = p
+		}
+	}
+	return latest, len(r.progress) > 0
+}
+
+// FileProgress returns the latest progress event of every file of the
+// current or last run that reported one, in the order of the config.
+func (r *Runner) FileProgress() []Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := make([]Progress, 0, len(r.progress))
+	for _, file := range r.config.ExcelFilePaths {
+		if p, ok := r.progress[file]; ok {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// Locks returns the advisory locks the current run holds or waits for, the
+// oldest first.
+func (r *Runner) Locks() []Lock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	locks := make([]Lock, 0, len(r.locks))
+	for _, lock := range r.locks {
+		locks = append(locks, lock)
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].ID < locks[j].ID })
+	return locks
+}
+
+// Runs returns how many runs have been started.
+func (r *Runner) Runs() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs
+}
@@ -0,0 +1,299 @@
+package processXlsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"xlsxtoSQL/rules"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+)
+
+// The hidden columns of a sheet exported for sync: the id_row of each row and
+// a stamp of its values at the time of the export.
+const (
+	syncKeyColumn     = "_key"
+	syncVersionColumn = "_version"
+)
+
+func isSyncColumn(column string) bool {
+	return column == syncKeyColumn || column == syncVersionColumn
+}
+
+// isSyncSheet reports whether a sheet was exported for sync.
+func isSyncSheet(header []string) bool {
+	return contains(header, syncKeyColumn) && contains(header, syncVersionColumn)
+}
+
+// versionSQL is the stamp of a row written to _version: a hash of its values
+// in their text form, which Export and syncSheet must compute over the same
+// columns in the same order and time zone.
+func versionSQL(columns []string) string {
+	return fmt.Sprintf("md5(ROW(%s)::text)", strings.Join(quoteIdentifiers(columns), ", "))
+}
+
+// columnSQLTypes returns the columns of a table in their order with their
+// full types, or no columns when the table does not exist.
+func columnSQLTypes(ctx context.Context, tx pgx.Tx, schema, table string) ([]string, map[string]string, error) {
+	rows, err := tx.Query(ctx, `SELECT a.attname, format_type(a.atttypid, a.atttypmod) FROM pg_attribute a
+WHERE a.attrelid = to_regclass($1) AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum`,
+		pq.QuoteIdentifier(schema)+"."+pq.QuoteIdentifier(table))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	var order []string
+	types := map[string]string{}
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+		}
+		order = append(order, name)
+		types[name] = typ
+	}
+	return order, types, rows.Err()
+}
+
+// distinctSQL compares a column with a cell the way a round trip through
+// Excel preserves it: empty text is an empty cell and timestamps keep whole
+// seconds.
+func distinctSQL(column, sqlType, param string) string {
+	column = pq.QuoteIdentifier(column)
+	switch {
+	case sqlType == "text" || strings.HasPrefix(sqlType, "character"):
+		return fmt.Sprintf("NULLIF(%s, '') IS DISTINCT FROM %s", column, param)
+	case strings.HasPrefix(sqlType, "timestamp"):
+		return fmt.Sprintf("date_trunc('second', %s) IS DISTINCT FROM date_trunc('second', %s)", column, param)
+	default:
+		return fmt.Sprintf("%s IS DISTINCT FROM %s", column, param)
+	}
+}
+
+// syncSheet applies a sheet exported for sync to its table. A row keeps its
+// id_row in _key: only the cells that differ from the table are written, and
+// only if the row still has the _version it was exported with. Rows changed
+// or deleted in the database since the export are reported as conflicts and
+// left alone. Rows without a key were added in Excel and are inserted.
+//
+// Failed rows and conflicts are reported but not quarantined: retrying them
+// would ignore their key.
+func syncSheet(ctx context.Context, tx pgx.Tx, validator *rules.Sheet, lin *lineage, schema, table string,
+	header, columnTypes []string, dataRows [][]string, sheet *SheetResult, progress *sheetProgress) error {
+	// Export writes times in UTC, so the versions are computed in UTC too.
+	if _, err := tx.Exec(ctx, "SET LOCAL TIME ZONE 'UTC'"); err != nil {
+		return fmt.Errorf("failed to set time zone: %w", err)
+	}
+	order, types, err := columnSQLTypes(ctx, tx, schema, table)
+	if err != nil {
+		return err
+	}
+	if len(order) == 0 {
+		return fmt.Errorf("table %s of the exported sheet does not exist", table)
+	}
+	if err := lin.addColumns(ctx, tx, schema, table); err != nil {
+		return err
+	}
+
+	index := map[string]int{}
+	for i, column := range header {
+		if column == "" || isSyncColumn(column) {
+			continue
+		}
+		if _, ok := types[column]; !ok || column == "id_row" || isLineageColumn(column) {
+			return fmt.Errorf("column %q of the exported sheet is not a data column of table %s", column, table)
+		}
+		index[column] = i
+	}
+	// The table order, which the version was computed in.
+	var columns []string
+	for _, column := range order {
+		if _, ok := index[column]; ok {
+			columns = append(columns, column)
+		}
+	}
+	s := &syncer{
+		tx:      tx,
+		table:   pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(table),
+		columns: columns,
+		types:   types,
+		version: versionSQL(columns),
+	}
+	keyIndex := indexOf(header, syncKeyColumn)
+	versionIndex := indexOf(header, syncVersionColumn)
+
+	for rowIndex, row := range dataRows {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("sheet %s rolled back: %w", table, err)
+		}
+		// Data rows start below the header, on Excel row 2.
+		excelRow := rowIndex + 2
+		cell := func(i int) string {
+			if i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		if !validator.Empty() {
+			reject, err := validateRow(ctx, validator, sheet, excelRow, row)
+			if err != nil {
+				return err
+			}
+			if reject != nil {
+				sheet.Failed++
+				sheet.reject(*reject)
+				progress.row(true)
+				continue
+			}
+		}
+
+		cells := make([]string, len(columns))
+		values := make([]interface{}, len(columns))
+		for j, column := range columns {
+			cells[j] = cell(index[column])
+			if strings.TrimSpace(cells[j]) != "" {
+				values[j] = cellValue(ctx, column, cells[j], strings.ToUpper(types[column]), rowIndex)
+			}
+		}
+		extColumns, extRow, extTypes := lin.extend(table, excelRow, columns, cells, make([]string, len(columns)))
+		s.lineageColumns = extColumns[len(columns):]
+		s.lineageValues = extRow[len(columns):]
+		s.lineageTypes = extTypes[len(columns):]
+
+		key := strings.TrimSpace(cell(keyIndex))
+		outcome, conflict, err := s.write(ctx, key, strings.TrimSpace(cell(versionIndex)), values)
+
+		switch {
+		case err != nil:
+			sheet.Failed++
+			sheet.reject(newReject(table, excelRow, header, columnTypes, row, err))
+		case conflict != "":
+			sheet.Conflicts++
+			sheet.reject(Reject{Sheet: table, Row: excelRow, Value: key, Code: "conflict", Message: conflict})
+			logger(ctx).Warn("sync conflict", "row", excelRow, "key", key, "conflict", conflict)
+		case outcome == rowInserted:
+			sheet.Inserted++
+		case outcome == rowUpdated:
+			sheet.Updated++
+		default:
+			sheet.Unchanged++
+		}
+		progress.row(err != nil || conflict != "")
+	}
+	return nil
+}
+
+type syncer struct {
+	tx      pgx.Tx
+	table   string
+	columns []string
+	types   map[string]string
+	version string
+	// The lineage columns of the current row.
+	lineageColumns, lineageValues, lineageTypes []string
+}
+
+// write inserts a row without key and updates the row whose id_row is key
+// otherwise.
+func (s *syncer) write(ctx context.Context, key, version string, values []interface{}) (rowOutcome, string, error) {
+	if key == "" {
+		return rowInserted, "", s.insert(ctx, values)
+	}
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid %s %q", syncKeyColumn, key)
+	}
+	return s.update(ctx, id, version, values)
+}
+
+// update writes the changed cells of the row with id_row id inside a
+// savepoint. It returns a conflict instead when the row is not at version.
+func (s *syncer) update(ctx context.Context, id int, version string, values []interface{}) (rowOutcome, string, error) {
+	selects := []string{s.version}
+	args := []interface{}{id}
+	for j, column := range s.columns {
+		args = append(args, values[j])
+		selects = append(selects, distinctSQL(column, s.types[column], fmt.Sprintf("$%d::%s", len(args), s.types[column])))
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id_row = $1 FOR UPDATE", strings.Join(selects, ", "), s.table)
+
+	var outcome rowOutcome
+	var conflict string
+	err := pgx.BeginFunc(ctx, s.tx, func(savepoint pgx.Tx) error {
+		var current string
+		changed := make([]bool, len(s.columns))
+		dest := []interface{}{&current}
+		for j := range changed {
+			dest = append(dest, &changed[j])
+		}
+		err := savepoint.QueryRow(ctx, query, args...).Scan(dest...)
+		if errors.Is(err, pgx.ErrNoRows) {
+			conflict = "row was deleted in the database since the export"
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if current != version {
+			conflict = "row was changed in the database since the export"
+			return nil
+		}
+
+		var sets []string
+		updateArgs := []interface{}{id}
+		for j, column := range s.columns {
+			if changed[j] {
+				updateArgs = append(updateArgs, values[j])
+				sets = append(sets, fmt.Sprintf("%s = $%d::%s", pq.QuoteIdentifier(column), len(updateArgs), s.types[column]))
+			}
+		}
+		if len(sets) == 0 {
+			outcome = rowUnchanged
+			return nil
+		}
+		for i, column := range s.lineageColumns {
+			updateArgs = append(updateArgs, s.lineageValues[i])
+			sets = append(sets, fmt.Sprintf("%s = $%d::%s", pq.QuoteIdentifier(column), len(updateArgs), s.lineageTypes[i]))
+		}
+		outcome = rowUpdated
+		update := fmt.Sprintf("UPDATE %s SET %s WHERE id_row = $1", s.table, strings.Join(sets, ", "))
+		_, err = savepoint.Exec(ctx, update, updateArgs...)
+		return err
+	})
+	return outcome, conflict, err
+}
+
+// insert adds a row that has no key yet after the last row of the table.
+func (s *syncer) insert(ctx context.Context, values []interface{}) error {
+	names := []string{"id_row"}
+	placeholders := []string{fmt.Sprintf("(SELECT COALESCE(max(id_row), 0) + 1 FROM %s)", s.table)}
+	var args []interface{}
+	for j, column := range s.columns {
+		args = append(args, values[j])
+		names = append(names, column)
+		placeholders = append(placeholders, fmt.Sprintf("$%d::%s", len(args), s.types[column]))
+	}
+	for i, column := range s.lineageColumns {
+		args = append(args, s.lineageValues[i])
+		names = append(names, column)
+		placeholders = append(placeholders, fmt.Sprintf("$%d::%s", len(args), s.lineageTypes[i]))
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", s.table,
+		strings.Join(quoteIdentifiers(names), ", "), strings.Join(placeholders, ", "))
+	return pgx.BeginFunc(ctx, s.tx, func(savepoint pgx.Tx) error {
+		_, err := savepoint.Exec(ctx, query, args...)
+		return err
+	})
+}
+
+func indexOf(slice []string, str string) int {
+	for i, s := range slice {
+		if s == str {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,93 @@
+package processXlsx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func Test_versionSQL(t *testing.T) {
+	tests := []struct {
+		columns []string
+		want    string
+	}{
+		{[]string{"id"}, `md5(ROW("id")::text)`},
+		{[]string{"name", "Due Date"}, `md5(ROW("name", "Due Date")::text)`},
+		{[]string{`say "hi"`}, `md5(ROW("say ""hi""")::text)`},
+	}
+	for _, tt := range tests {
+		if got := versionSQL(tt.columns); got != tt.want {
+			t.Errorf("versionSQL(%q) = %s, want %s", tt.columns, got, tt.want)
+		}
+	}
+}
+
+func Test_distinctSQL(t *testing.T) {
+	tests := []struct {
+		column, sqlType string
+		want            string
+	}{
+		{"name", "text", `NULLIF("name", '') IS DISTINCT FROM $1::text`},
+		{"code", "character varying(10)", `NULLIF("code", '') IS DISTINCT FROM $1::text`},
+		{"at", "timestamp without time zone", `date_trunc('second', "at") IS DISTINCT FROM date_trunc('second', $1::text)`},
+		{"at", "timestamp with time zone", `date_trunc('second', "at") IS DISTINCT FROM date_trunc('second', $1::text)`},
+		{"amount", "numeric", `"amount" IS DISTINCT FROM $1::text`},
+		{"day", "date", `"day" IS DISTINCT FROM $1::text`},
+	}
+	for _, tt := range tests {
+		if got := distinctSQL(tt.column, tt.sqlType, "$1::text"); got != tt.want {
+			t.Errorf("distinctSQL(%q, %q) = %s, want %s", tt.column, tt.sqlType, got, tt.want)
+		}
+	}
+}
+
+func Test_syncerWrite(t *testing.T) {
+	tests := []struct {
+		name, key, version string
+		rows               []fakeRow
+		want               rowOutcome
+		conflict           bool
+		wantErr            bool
+		exec               string
+	}{
+		{name: "no key", key: "", want: rowInserted,
+			exec: `INSERT INTO "s"."t" ("id_row", "name", "amount", "_load_id") VALUES ((SELECT COALESCE(max(id_row), 0) + 1 FROM "s"."t"), $1::text, $2::numeric, $3::TEXT)`},
+		{name: "unchanged", key: "3", version: "v1", rows: []fakeRow{{values: []interface{}{"v1", false, false}}}, want: rowUnchanged},
+		{name: "changed cell", key: "3", version: "v1", rows: []fakeRow{{values: []interface{}{"v1", false, true}}}, want: rowUpdated,
+			exec: `UPDATE "s"."t" SET "amount" = $2::numeric, "_load_id" = $3::TEXT WHERE id_row = $1`},
+		{name: "changed in the database", key: "3", version: "v1", rows: []fakeRow{{values: []interface{}{"v2", true, true}}}, conflict: true},
+		{name: "deleted in the database", key: "3", version: "v1", rows: []fakeRow{{err: pgx.ErrNoRows}}, conflict: true},
+		{name: "invalid key", key: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		tx := &fakeTx{rows: tt.rows}
+		s := &syncer{
+			tx:             tx,
+			table:          `"s"."t"`,
+			columns:        []string{"name", "amount"},
+			types:          map[string]string{"name": "text", "amount": "numeric"},
+			version:        versionSQL([]string{"name", "amount"}),
+			lineageColumns: []string{"_load_id"},
+			lineageValues:  []string{"load-1"},
+			lineageTypes:   []string{"TEXT"},
+		}
+		outcome, conflict, err := s.write(context.Background(), tt.key, tt.version, []interface{}{"a", "2.5"})
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: write() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if (conflict != "") != tt.conflict {
+			t.Errorf("%s: write() conflict = %q, want conflict %v", tt.name, conflict, tt.conflict)
+		}
+		if !tt.conflict && !tt.wantErr && outcome != tt.want {
+			t.Errorf("%s: write() = %v, want %v", tt.name, outcome, tt.want)
+		}
+		var exec string
+		if len(tx.execs) > 0 {
+			exec = tx.execs[len(tx.execs)-1]
+		}
+		if len(tx.execs) > 1 || exec != tt.exec {
+			t.Errorf("%s: write() ran %q, want %q", tt.name, tx.execs, tt.exec)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"xlsxtoSQL/datatype"
+)
+
+// Severity decides what a violated rule does to the row.
+type Severity string
+
+const (
+	// SeverityWarn logs the violation and loads the row anyway.
+	SeverityWarn Severity = "warn"
+	// SeverityReject skips the row and quarantines it like a failed insert.
+	SeverityReject Severity = "reject"
+	// SeverityFailSheet rolls back the whole sheet.
+	SeverityFailSheet Severity = "fail_sheet"
+)
+
+// Rule asserts the values of one column. Every check that is set applies;
+// empty cells only fail NotNull.
+type Rule struct {
+	Name string `yaml:"name" json:"name,omitempty"`
+	// Sheet limits the rule to one sheet. Without it the rule applies to
+	// every sheet that has the column.
+	Sheet    string   `yaml:"sheet" json:"sheet,omitempty"`
+	Column   string   `yaml:"column" json:"column"`
+	Severity Severity `yaml:"severity" json:"severity,omitempty"`
+
+	NotNull bool     `yaml:"not_null" json:"not_null,omitempty"`
+	Unique  bool     `yaml:"unique" json:"unique,omitempty"`
+	Regex   string   `yaml:"regex" json:"regex,omitempty"`
+	Min     *float64 `yaml:"min" json:"min,omitempty"`
+	Max     *float64 `yaml:"max" json:"max,omitempty"`
+	In      []string `yaml:"in" json:"in,omitempty"`
+	// Within requires a date no older than a period such as "30d", "12w",
+	// "6m" or "5y", and not in the future.
+	Within string `yaml:"within" json:"within,omitempty"`
+}
+
+// Violation is a failed check of a rule on one row.
+type Violation struct {
+	Rule     string   `json:"rule"`
+	Sheet    string   `json:"sheet"`
+	Row      int      `json:"row"`
+	Column   string   `json:"column"`
+	Value    string   `json:"value,omitempty"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+type compiled struct {
+	Rule
+	regex  *regexp.Regexp
+	in     map[string]bool
+	within func(now time.Time) time.Time
+}
+
+// Set is a validated list of rules.
+type Set struct {
+	rules []compiled
+	now   func() time.Time
+}
+
+// Compile checks the rules and prepares them for evaluation.
+func Compile(rules []Rule) (*Set, error) {
+	set := &Set{now: time.Now}
+	for i, r := range rules {
+		if r.Column == "" {
+			return nil, fmt.Errorf("rule %d: column is required", i+1)
+		}
+		if r.Name == "" {
+			r.Name = r.Column
+		}
+		switch r.Severity {
+		case "":
+			r.Severity = SeverityReject
+		case SeverityWarn, SeverityReject, SeverityFailSheet:
+		default:
+			return nil, fmt.Errorf("rule %q: unknown severity %q", r.Name, r.Severity)
+		}
+
+		c := compiled{Rule: r}
+		if r.Regex != "" {
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: invalid regex: %w", r.Name, err)
+			}
+			c.regex = re
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return nil, fmt.Errorf("rule %q: min is greater than max", r.Name)
+		}
+		if len(r.In) > 0 {
+			c.in = make(map[string]bool, len(r.In))
+			for _, v := range r.In {
+				c.in[v] = true
+			}
+		}
+		if r.Within != "" {
+			within, err := parsePeriod(r.Within)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+			c.within = within
+		}
+		if !r.NotNull && !r.Unique && c.regex == nil && r.Min == nil && r.Max == nil && c.in == nil && c.within == nil {
+			return nil, fmt.Errorf("rule %q: no check configured", r.Name)
+		}
+		set.rules = append(set.rules, c)
+	}
+	return set, nil
+}
+
+// parsePeriod reads "<n><unit>" with the units d, w, m and y and returns the
+// function computing the oldest accepted time.
+func parsePeriod(period string) (func(time.Time) time.Time, error) {
+	period = strings.TrimSpace(period)
+	if len(period) < 2 {
+		return nil, fmt.Errorf("invalid period %q", period)
+	}
+	n, err := strconv.Atoi(period[:len(period)-1])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid period %q", period)
+	}
+	switch period[len(period)-1] {
+	case 'd':
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, -n) }, nil
+	case 'w':
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, -7*n) }, nil
+	case 'm':
+		return func(t time.Time) time.Time { return t.AddDate(0, -n, 0) }, nil
+	case 'y':
+		return func(t time.Time) time.Time { return t.AddDate(-n, 0, 0) }, nil
+	default:
+		return nil, fmt.Errorf("invalid period %q, use d, w, m or y", period)
+	}
+}
+
+// Sheet evaluates the rules of one sheet. It remembers the values seen by
+// unique rules, so it must not be shared between sheets.
+type Sheet struct {
+	name  string
+	rules []compiled
+	index []int
+	seen  []map[string]int
+	now   time.Time
+}
+
+// ForSheet selects the rules that apply to a sheet with the given header. A
+// rule naming the sheet explicitly must find its column there.
+func (s *Set) ForSheet(sheet string, header []string) (*Sheet, error) {
+	v := &Sheet{name: sheet, now: s.now()}
+	for _, r := range s.rules {
+		if r.Sheet != "" && r.Sheet != sheet {
+			continue
+		}
+		column := -1
+		for i, name := range header {
+			if name == r.Column {
+				column = i
+				break
+			}
+		}
+		if column < 0 {
+			if r.Sheet != "" {
+				return nil, fmt.Errorf("rule %q: sheet %s has no column %q", r.Name, sheet, r.Column)
+			}
+			continue
+		}
+		v.rules = append(v.rules, r)
+		v.index = append(v.index, column)
+		v.seen = append(v.seen, map[string]int{})
+	}
+	return v, nil
+}
+
+// Empty reports whether no rule applies to the sheet.
+func (v *Sheet) Empty() bool {
+	return v == nil || len(v.rules) == 0
+}
+
+// Check evaluates a data row. excelRow is the row number shown in Excel and
+// is only used to describe the violations.
+func (v *Sheet) Check(excelRow int, row []string) []Violation {
+	var violations []Violation
+	for i, r := range v.rules {
+		value := ""
+		if v.index[i] < len(row) {
+			value = row[v.index[i]]
+		}
+		for _, message := range v.check(i, r, excelRow, strings.TrimSpace(value)) {
+			violations = append(violations, Violation{
+				Rule:     r.Name,
+				Sheet:    v.name,
+				Row:      excelRow,
+				Column:   r.Column,
+				Value:    value,
+				Severity: r.Severity,
+				Message:  message,
+			})
+		}
+	}
+	return violations
+}
+
+func (v *Sheet) check(i int, r compiled, excelRow int, value string) []string {
+	if value == "" {
+		if r.NotNull {
+			return []string{"value is required"}
+		}
+		return nil
+	}
+
+	var messages []string
+	if r.Unique {
+		if first, ok := v.seen[i][value]; ok {
+			messages = append(messages, fmt.Sprintf("duplicate of row %d", first))
+		} else {
+			v.seen[i][value] = excelRow
+		}
+	}
+	if r.regex != nil && !r.regex.MatchString(value) {
+		messages = append(messages, fmt.Sprintf("does not match %s", r.Regex))
+	}
+	if r.Min != nil || r.Max != nil {
+		n, err := strconv.ParseFloat(value, 64)
+		switch {
+		case err != nil:
+			messages = append(messages, "not a number")
+		case r.Min != nil && n < *r.Min:
+			messages = append(messages, fmt.Sprintf("less than %g", *r.Min))
+		case r.Max != nil && n > *r.Max:
+			messages = append(messages, fmt.Sprintf("greater than %g", *r.Max))
+		}
+	}
+	if r.in != nil && !r.in[value] {
+		messages = append(messages, fmt.Sprintf("not one of %s", strings.Join(r.In, ", ")))
+	}
+	if r.within != nil {
+		converted, err := datatype.ConvertToDate(value)
+		if err != nil {
+			messages = append(messages, "not a date")
+		} else {
+			date, _ := time.Parse("2006-01-02", converted)
+			if date.Before(r.within(v.now).Truncate(24*time.Hour)) || date.After(v.now) {
+				messages = append(messages, fmt.Sprintf("not within the last %s", r.Within))
+			}
+		}
+	}
+	return messages
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"xlsxtoSQL/config"
+	"xlsxtoSQL/metrics"
+	"xlsxtoSQL/processXlsx"
+	"xlsxtoSQL/tracing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Schema is the PostgreSQL schema holding the API server's own tables.
+const Schema = "xlsxtosql_control"
+
+var migrations = []string{
+	`CREATE SCHEMA IF NOT EXISTS ` + Schema,
+	`CREATE TABLE IF NOT EXISTS ` + Schema + `.jobs (
+		id TEXT PRIMARY KEY,
+		config JSONB NOT NULL,
+		display TEXT NOT NULL,
+		once BOOLEAN NOT NULL,
+		status TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS ` + Schema + `.runs (
+		id BIGSERIAL PRIMARY KEY,
+		job_id TEXT NOT NULL REFERENCES ` + Schema + `.jobs (id) ON DELETE CASCADE,
+		number INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		started_at TIMESTAMPTZ NOT NULL,
+		finished_at TIMESTAMPTZ NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		inserted INTEGER NOT NULL,
+		updated INTEGER NOT NULL,
+		failed INTEGER NOT NULL,
+		results JSONB NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS runs_job_id_idx ON ` + Schema + `.runs (job_id, started_at)`,
+}
+
+// Job is a persisted job definition together with its last known status.
+type Job struct {
+	ID      string
+	Config  config.Config
+	Display string
+	Once    bool
+	Status  string
+}
+
+// Store persists jobs and their run history in a control schema.
+type Store struct {
+	pool    *pgxpool.Pool
+	untrack func()
+}
+
+// Open connects to the control database and creates the control schema if
+// it does not exist yet.
+func Open(ctx context.Context, url string) (*Store, error) {
+	poolConfig, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to control database: %w", err)
+	}
+	poolConfig.ConnConfig.Tracer = tracing.QueryTracer{}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to control database: %w", err)
+	}
+	for _, migration := range migrations {
+		if _, err := pool.Exec(ctx, migration); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to migrate control schema: %w", err)
+		}
+	}
+	slog.Info("control schema ready", "schema", Schema)
+	return &Store{pool: pool, untrack: metrics.TrackPool("control", pool)}, nil
+}
+
+func (s *Store) Close() {
+	s.untrack()
+	s.pool.Close()
+}
+
+// SaveJob inserts or replaces a job definition.
+func (s *Store) SaveJob(ctx context.Context, job Job) error {
+	cfgJSON, err := json.Marshal(job.Config)
+	if err != nil {
+		return fmt.Errorf("failed to encode config of job %s: %w", job.ID, err)
+	}
+	_, err = s.pool.Exec(ctx, `
+		INSERT INTO `+Schema+`.jobs (id, config, display, once, status)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET
+			config = EXCLUDED.config, display = EXCLUDED.display,
+			once = EXCLUDED.once, status = EXCLUDED.status, updated_at = now()`,
+		job.ID, cfgJSON, job.Display, job.Once, job.Status)
+	if err != nil {
+		return fmt.Errorf("failed to save job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func (s *Store) SetStatus(ctx context.Context, id, status string) error {
+	_, err := s.pool.Exec(ctx,
+		`UPDATE `+Schema+`.jobs SET status = $2, updated_at = now() WHERE id = $1`, id, status)
+	if err != nil {
+		return fmt.Errorf("failed to update status of job %s: %w", id, err)
+	}
+	return nil
+}
+
+// DeleteJob removes a job and, through the foreign key, its run history.
+func (s *Store) DeleteJob(ctx context.Context, id string) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM `+Schema+`.jobs WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete job %s: %w", id, err)
+	}
+	return nil
+}
+
+// Jobs returns every stored job ordered by creation time.
+func (s *Store) Jobs(ctx context.Context) ([]Job, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT id, config, display, once, status FROM `+Schema+`.jobs ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var job Job
+		var cfgJSON []byte
+		if err := rows.Scan(&job.ID, &cfgJSON, &job.Display, &job.Once, &job.Status); err != nil {
+			return nil, fmt.Errorf("failed to read job: %w", err)
+		}
+		if err := json.Unmarshal(cfgJSON, &job.Config); err != nil {
+			return nil, fmt.Errorf("failed to decode config of job %s: %w", job.ID, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// AddRun records one finished run of a job.
+func (s *Store) AddRun(ctx context.Context, jobID string, run processXlsx.Run) error {
+	results, err := json.Marshal(run.Results)
+	if err != nil {
+		return fmt.Errorf("failed to encode results of job %s: %w", jobID, err)
+	}
+	_, err = s.pool.Exec(ctx, `
+		INSERT INTO `+Schema+`.runs
+			(job_id, number, status, started_at, finished_at, error, inserted, updated, failed, results)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		jobID, run.Number, run.Status, run.StartedAt, run.FinishedAt, run.Error,
+		run.Inserted, run.Updated, run.Failed, results)
+	if err != nil {
+		return fmt.Errorf("failed to record run of job %s: %w", jobID, err)
+	}
+	return nil
+}
+
+// Runs returns the run history of a job, newest first, at most limit entries.
+func (s *Store) Runs(ctx context.Context, jobID string, limit int) ([]processXlsx.Run, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT number, status, started_at, finished_at, error, inserted, updated, failed, results
+		FROM `+Schema+`.runs WHERE job_id = $1
+		ORDER BY started_at DESC LIMIT $2`, jobID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs of job %s: %w", jobID, err)
+	}
+	defer rows.Close()
+
+	runs := []processXlsx.Run{}
+	for rows.Next() {
+		var run processXlsx.Run
+		var results []byte
+		err := rows.Scan(&run.Number, &run.Status, &run.StartedAt, &run.FinishedAt, &run.Error,
+			&run.Inserted, &run.Updated, &run.Failed, &results)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read run: %w", err)
+		}
+		if err := json.Unmarshal(results, &run.Results); err != nil {
+			return nil, fmt.Errorf("failed to decode results of job %s: %w", jobID, err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"xlsxtoSQL/auth"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+func Test_jwtAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"n":   enc.EncodeToString(key.N.Bytes()),
+		"e":   enc.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := auth.NewJWTAuthenticator(auth.JWTConfig{JWKSFile: jwksFile, Issuer: "issuer", Audience: "xlsxtosql"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := map[string]interface{}{
+		"sub":  "alice",
+		"iss":  "issuer",
+		"aud":  []string{"xlsxtosql"},
+		"exp":  time.Now().Add(time.Hour).Unix(),
+		"role": []string{"viewer", "operator"},
+	}
+	req := httptest.NewRequest("GET", "/api/instances", nil)
+	req.Header.Set("Authorization", "Bearer "+signJWT(t, key, "k1", valid))
+	p, err := a.Authenticate(req)
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if p.Name != "alice" || p.Role != auth.RoleOperator {
+		t.Errorf("got %s/%s, want alice/operator", p.Name, p.Role)
+	}
+
+	expired := map[string]interface{}{"sub": "alice", "iss": "issuer", "aud": "xlsxtosql", "role": "admin",
+		"exp": time.Now().Add(-time.Hour).Unix()}
+	req.Header.Set("Authorization", "Bearer "+signJWT(t, key, "k1", expired))
+	if _, err := a.Authenticate(req); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("expired token: got %v, want ErrInvalidCredentials", err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	req.Header.Set("Authorization", "Bearer "+signJWT(t, other, "k1", valid))
+	if _, err := a.Authenticate(req); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("foreign signature: got %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func Test_staticAuthenticators(t *testing.T) {
+	tokens, err := auth.NewTokenAuthenticator([]auth.Token{{Name: "ci", Token: "secret", Role: "operator"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	usersFile := filepath.Join(t.TempDir(), "users.yaml")
+	users := "users:\n  - username: bob\n    password_hash: " + string(hash) + "\n    role: viewer\n"
+	if err := os.WriteFile(usersFile, []byte(users), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	basic, err := auth.LoadUsersFile(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := auth.Chain{tokens, basic}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, err := chain.Authenticate(req); !errors.Is(err, auth.ErrNoCredentials) {
+		t.Errorf("no credentials: got %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	if p, err := chain.Authenticate(req); err != nil || p.Role != auth.RoleOperator {
+		t.Errorf("token: got %v, %v", p, err)
+	}
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	if _, err := chain.Authenticate(req); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("wrong token: got %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("bob", "pw")
+	if p, err := chain.Authenticate(req); err != nil || p.Role != auth.RoleViewer {
+		t.Errorf("basic: got %v, %v", p, err)
+	}
+	req.SetBasicAuth("bob", "nope")
+	if _, err := chain.Authenticate(req); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("wrong password: got %v", err)
+	}
+	req.SetBasicAuth("alice", "pw")
+	if _, err := chain.Authenticate(req); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("unknown user: got %v", err)
+	}
+}
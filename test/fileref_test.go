@@ -0,0 +1,52 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"xlsxtoSQL/fileref"
+)
+
+func Test_fileResolver(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(root, "book.xlsx"), []byte("PK"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.xlsx"), []byte("PK"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.xlsx"), filepath.Join(root, "link.xlsx")); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := fileref.NewResolver([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want error
+	}{
+		{"book.xlsx", nil},
+		{filepath.Join(root, "book.xlsx"), nil},
+		{"", fileref.ErrInvalidPath},
+		{"missing.xlsx", fileref.ErrNotFound},
+		{"../../etc/passwd", fileref.ErrForbidden},
+		{"sub/../../book.xlsx", fileref.ErrForbidden},
+		{filepath.Join(outside, "secret.xlsx"), fileref.ErrForbidden},
+		{"link.xlsx", fileref.ErrForbidden},
+	}
+	for _, tt := range tests {
+		_, err := resolver.Resolve(tt.name)
+		if tt.want == nil && err != nil {
+			t.Errorf("Resolve(%q) = %v, want no error", tt.name, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("Resolve(%q) = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"xlsxtoSQL/joblog"
+)
+
+// messages returns the messages of entries.
+func messages(entries []joblog.Entry) []string {
+	result := []string{}
+	for _, e := range entries {
+		result = append(result, e.Message)
+	}
+	return result
+}
+
+func Test_jobLogRingBuffer(t *testing.T) {
+	b, err := joblog.NewBuffer(3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(b.Handler(nil))
+	for i := 1; i <= 5; i++ {
+		if i == 4 {
+			logger.Warn(fmt.Sprint(i))
+		} else {
+			logger.Info(fmt.Sprint(i))
+		}
+	}
+
+	tests := []struct {
+		n     int
+		level slog.Level
+		want  []string
+	}{
+		{10, slog.LevelInfo, []string{"3", "4", "5"}},
+		{2, slog.LevelInfo, []string{"4", "5"}},
+		{10, slog.LevelWarn, []string{"4"}},
+		{10, slog.LevelError, []string{}},
+		{0, slog.LevelInfo, []string{}},
+	}
+	for _, tt := range tests {
+		if got := messages(b.Tail(tt.n, tt.level)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tail(%d, %s) = %q, want %q", tt.n, tt.level, got, tt.want)
+		}
+	}
+	if tail := b.Tail(1, slog.LevelInfo); tail[0].Seq != 5 {
+		t.Errorf("Seq of the last entry = %d, want 5", tail[0].Seq)
+	}
+}
+
+func Test_jobLogReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	b, err := joblog.NewBuffer(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(b.Handler(nil))
+	logger.Info("first")
+	logger.Info("second", "rows", 3)
+	logger.Info("third")
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := joblog.NewBuffer(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	slog.New(reloaded.Handler(nil)).Info("fourth")
+
+	tail := reloaded.Tail(10, slog.LevelInfo)
+	if got, want := messages(tail), []string{"third", "fourth"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tail() after reload = %q, want %q", got, want)
+	}
+	if tail[1].Seq != 4 {
+		t.Errorf("Seq after reload = %d, want 4", tail[1].Seq)
+	}
+}
+
+func Test_jobLogSubscribe(t *testing.T) {
+	b, err := joblog.NewBuffer(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(b.Handler(nil))
+	first, unsubscribeFirst := b.Subscribe()
+	second, unsubscribeSecond := b.Subscribe()
+	defer unsubscribeSecond()
+
+	logger.Info("both", "sheet", "Data")
+	for _, ch := range []<-chan joblog.Entry{first, second} {
+		select {
+		case e := <-ch:
+			if e.Message != "both" || e.Attrs["sheet"] != "Data" {
+				t.Errorf("subscriber received %+v, want the entry both with its sheet", e)
+			}
+		default:
+			t.Error("subscriber received nothing")
+		}
+	}
+
+	unsubscribeFirst()
+	logger.Info("second only")
+	select {
+	case e := <-first:
+		t.Errorf("unsubscribed channel received %+v", e)
+	default:
+	}
+	if e := <-second; e.Message != "second only" {
+		t.Errorf("subscriber received %q, want %q", e.Message, "second only")
+	}
+
+	// A subscriber that does not read misses entries instead of blocking.
+	for i := 0; i < 300; i++ {
+		logger.Info("flood")
+	}
+	if n := len(second); n != cap(second) {
+		t.Errorf("slow subscriber holds %d entries, want its buffer of %d", n, cap(second))
+	}
+}
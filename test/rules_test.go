@@ -0,0 +1,72 @@
+package test
+
+import (
+	"testing"
+	"time"
+	"xlsxtoSQL/rules"
+)
+
+func Test_rules(t *testing.T) {
+	zero := 0.0
+	set, err := rules.Compile([]rules.Rule{
+		{Column: "email", Regex: `^[^@\s]+@[^@\s]+$`, NotNull: true},
+		{Column: "amount", Min: &zero, Severity: rules.SeverityWarn},
+		{Column: "status", In: []string{"A", "B", "C"}},
+		{Column: "id", Unique: true, Severity: rules.SeverityFailSheet},
+		{Column: "day", Within: "5y"},
+		{Sheet: "Other", Column: "missing", NotNull: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sheet, err := set.ForSheet("Sheet1", []string{"id", "email", "amount", "status", "day"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent := time.Now().AddDate(0, -1, 0).Format("2006-01-02")
+	old := time.Now().AddDate(-6, 0, 0).Format("2006-01-02")
+
+	tests := []struct {
+		row  []string
+		want []string
+	}{
+		{[]string{"1", "a@b.c", "10", "A", recent}, nil},
+		{[]string{"2", "", "", "", ""}, []string{"email"}},
+		{[]string{"3", "nope", "-1", "D", old}, []string{"email", "amount", "status", "day"}},
+		{[]string{"1", "a@b.c", "x", "B", recent}, []string{"amount", "id"}},
+	}
+	for i, tt := range tests {
+		violations := sheet.Check(i+2, tt.row)
+		got := map[string]bool{}
+		for _, v := range violations {
+			got[v.Column] = true
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("row %d: got %v, want violations of %v", i+2, violations, tt.want)
+			continue
+		}
+		for _, column := range tt.want {
+			if !got[column] {
+				t.Errorf("row %d: no violation of %s in %v", i+2, column, violations)
+			}
+		}
+	}
+
+	if _, err := set.ForSheet("Other", []string{"id"}); err == nil {
+		t.Error("rule of sheet Other with a missing column was accepted")
+	}
+
+	invalid := [][]rules.Rule{
+		{{Column: "a"}},
+		{{Column: "a", NotNull: true, Severity: "panic"}},
+		{{Column: "a", Regex: "("}},
+		{{Column: "a", Within: "5x"}},
+		{{NotNull: true}},
+	}
+	for _, r := range invalid {
+		if _, err := rules.Compile(r); err == nil {
+			t.Errorf("rules %+v were accepted", r)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xlsxtoSQL/uploads"
+)
+
+func Test_uploadStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := uploads.NewStore(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workbook := []byte("PK\x03\x04workbook")
+	xlsxType := "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+	tests := []struct {
+		name        string
+		contentType string
+		content     []byte
+		want        error
+	}{
+		{"book.xlsx", xlsxType, workbook, nil},
+		{"macros.XLSM", "application/zip", []byte("PK\x03\x04macros"), nil},
+		{"book.xls", xlsxType, workbook, uploads.ErrNotWorkbook},
+		{"", xlsxType, workbook, uploads.ErrNotWorkbook},
+		{"book.xlsx", "text/csv", workbook, uploads.ErrNotWorkbook},
+		{"book.xlsx", "not a type;", workbook, uploads.ErrNotWorkbook},
+		{"book.xlsx", xlsxType, []byte("id,name\n1,a\n"), uploads.ErrNotWorkbook},
+		{"book.xlsx", xlsxType, []byte("PK"), uploads.ErrNotWorkbook},
+		{"book.xlsx", xlsxType, nil, uploads.ErrNotWorkbook},
+		{"big.xlsx", xlsxType, append([]byte("PK\x03\x04"), bytes.Repeat([]byte("x"), 60)...), nil},
+		{"huge.xlsx", xlsxType, append([]byte("PK\x03\x04"), bytes.Repeat([]byte("x"), 61)...), uploads.ErrTooLarge},
+	}
+	for _, tt := range tests {
+		_, _, err := store.Save(tt.name, tt.contentType, bytes.NewReader(tt.content))
+		if tt.want == nil && err != nil {
+			t.Errorf("Save(%q, %q) = %v, want no error", tt.name, tt.contentType, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("Save(%q, %q) = %v, want %v", tt.name, tt.contentType, err, tt.want)
+		}
+	}
+
+	// Rejected uploads leave nothing behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".upload-") {
+			t.Errorf("temporary file %s left in the store", entry.Name())
+		}
+	}
+	files, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("List() returned %d files, want 3", len(files))
+	}
+}
+
+func Test_uploadStoreDedup(t *testing.T) {
+	store, err := uploads.NewStore(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, existed, err := store.Save("book.xlsx", "", bytes.NewReader([]byte("PK\x03\x04one")))
+	if err != nil || existed {
+		t.Fatalf("Save() = %v, existed %v", err, existed)
+	}
+	again, existed, err := store.Save("copy.xlsx", "", bytes.NewReader([]byte("PK\x03\x04one")))
+	if err != nil || !existed {
+		t.Fatalf("Save() of the same content = %v, existed %v, want existing file", err, existed)
+	}
+	if again.ID != first.ID || again.Name != "book.xlsx" {
+		t.Errorf("Save() of the same content = %+v, want %+v", again, first)
+	}
+	other, existed, err := store.Save("book.xlsx", "", bytes.NewReader([]byte("PK\x03\x04two")))
+	if err != nil || existed || other.ID == first.ID {
+		t.Errorf("Save() of other content = %+v, %v, existed %v, want a new file", other, err, existed)
+	}
+
+	path, err := store.Path(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "book.xlsx" {
+		t.Errorf("Path() = %s, want the original name", path)
+	}
+	if err := store.Delete(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(first.ID); !errors.Is(err, uploads.ErrNotFound) {
+		t.Errorf("Get() after Delete() = %v, want %v", err, uploads.ErrNotFound)
+	}
+}
+
+func Test_uploadStoreIDs(t *testing.T) {
+	dir := t.TempDir()
+	store, err := uploads.NewStore(filepath.Join(dir, "store"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A directory next to the store that a crafted ID could point at.
+	if err := os.MkdirAll(filepath.Join(dir, "outside"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "outside", "meta.json"), []byte(`{"id":"outside"}`), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"", "../outside", "0123456789abcdeg", "0123456789abcde", "0123456789abcdef0", "0123456789abcdef"} {
+		if _, err := store.Get(id); !errors.Is(err, uploads.ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want %v", id, err, uploads.ErrNotFound)
+		}
+		if _, err := store.Path(id); !errors.Is(err, uploads.ErrNotFound) {
+			t.Errorf("Path(%q) = %v, want %v", id, err, uploads.ErrNotFound)
+		}
+		if err := store.Delete(id); !errors.Is(err, uploads.ErrNotFound) {
+			t.Errorf("Delete(%q) = %v, want %v", id, err, uploads.ErrNotFound)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside")); err != nil {
+		t.Errorf("Delete() removed a directory outside the store: %v", err)
+	}
+}
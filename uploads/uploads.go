@@ -0,0 +1,249 @@
+package uploads
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("file not found")
+	ErrTooLarge    = errors.New("file is too large")
+	ErrNotWorkbook = errors.New("file is not an xlsx workbook")
+)
+
+// xlsx workbooks are zip archives, which start with a local file header.
+var zipMagic = []byte("PK\x03\x04")
+
+var allowedExtensions = []string{".xlsx", ".xlsm"}
+
+var allowedContentTypes = []string{
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/vnd.ms-excel.sheet.macroenabled.12",
+	"application/zip",
+	"application/x-zip-compressed",
+	"application/octet-stream",
+	"",
+}
+
+const metaFile = "meta.json"
+
+// File describes an uploaded workbook.
+type File struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	ContentType string    `json:"content_type"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// Store keeps uploaded workbooks under dir, one directory per file named
+// after its ID. The ID is derived from the checksum, so uploading the same
+// content twice returns the existing file.
+type Store struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+func NewStore(dir string, maxSize int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return &Store{dir: dir, maxSize: maxSize}, nil
+}
+
+// Save validates and stores a workbook. It reports whether an identical file
+// had already been uploaded, in which case the existing file is returned.
+func (s *Store) Save(name, contentType string, r io.Reader) (File, bool, error) {
+	name = filepath.Base(strings.TrimSpace(name))
+	if name == "." || name == string(filepath.Separator) || name == "" {
+		return File{}, false, fmt.Errorf("%w: missing file name", ErrNotWorkbook)
+	}
+	if !contains(allowedExtensions, strings.ToLower(filepath.Ext(name))) {
+		return File{}, false, fmt.Errorf("%w: unsupported extension %q", ErrNotWorkbook, filepath.Ext(name))
+	}
+	mediaType := contentType
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return File{}, false, fmt.Errorf("%w: invalid content type %q", ErrNotWorkbook, contentType)
+		}
+		mediaType = parsed
+	}
+	if !contains(allowedContentTypes, strings.ToLower(mediaType)) {
+		return File{}, false, fmt.Errorf("%w: unsupported content type %q", ErrNotWorkbook, contentType)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return File{}, false, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	head := make([]byte, len(zipMagic))
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return File{}, false, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if !bytes.Equal(head[:n], zipMagic) {
+		return File{}, false, fmt.Errorf("%w: content is not a zip archive", ErrNotWorkbook)
+	}
+
+	hash := sha256.New()
+	w := io.MultiWriter(tmp, hash)
+	if _, err := w.Write(head); err != nil {
+		return File{}, false, fmt.Errorf("failed to write upload: %w", err)
+	}
+	size, err := io.Copy(w, io.LimitReader(r, s.maxSize-int64(n)+1))
+	if err != nil {
+		return File{}, false, fmt.Errorf("failed to write upload: %w", err)
+	}
+	size += int64(n)
+	if size > s.maxSize {
+		return File{}, false, ErrTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return File{}, false, fmt.Errorf("failed to write upload: %w", err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	file := File{
+		ID:          sum[:16],
+		Name:        name,
+		Size:        size,
+		SHA256:      sum,
+		ContentType: mediaType,
+		UploadedAt:  time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, err := s.get(file.ID); err == nil && existing.SHA256 == sum {
+		return existing, true, nil
+	}
+
+	dir := filepath.Join(s.dir, file.ID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return File{}, false, fmt.Errorf("failed to create file directory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		os.RemoveAll(dir)
+		return File{}, false, fmt.Errorf("failed to store upload: %w", err)
+	}
+	meta, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		os.RemoveAll(dir)
+		return File{}, false, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, metaFile), meta, 0o640); err != nil {
+		os.RemoveAll(dir)
+		return File{}, false, fmt.Errorf("failed to store file metadata: %w", err)
+	}
+	return file, false, nil
+}
+
+// List returns the uploaded files, newest first.
+func (s *Store) List() ([]File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload directory: %w", err)
+	}
+	files := []File{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		file, err := s.get(entry.Name())
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].UploadedAt.After(files[j].UploadedAt)
+	})
+	return files, nil
+}
+
+func (s *Store) Get(id string) (File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(id)
+}
+
+// Path returns the location of the stored workbook with the given ID.
+func (s *Store) Path(id string) (string, error) {
+	file, err := s.Get(id)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, file.ID, file.Name), nil
+}
+
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.get(id); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, id)); err != nil {
+		return fmt.Errorf("failed to delete file %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s *Store) get(id string) (File, error) {
+	if !validID(id) {
+		return File{}, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id, metaFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return File{}, ErrNotFound
+	}
+	if err != nil {
+		return File{}, fmt.Errorf("failed to read metadata of file %s: %w", id, err)
+	}
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return File{}, fmt.Errorf("failed to decode metadata of file %s: %w", id, err)
+	}
+	return file, nil
+}
+
+// validID keeps IDs to the hex form produced by Save so that they can never
+// address anything outside the store directory.
+func validID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func contains(slice []string, str string) bool {
+	for _, v := range slice {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
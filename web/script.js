@@ -1,3 +1,20 @@
+const tokenInput = document.getElementById('apiToken');
+tokenInput.value = localStorage.getItem('apiToken') || '';
+tokenInput.addEventListener('change', () => {
+    localStorage.setItem('apiToken', tokenInput.value);
+    updateInstances();
+    updateFiles();
+    updateConnections();
+});
+
+function apiFetch(url, options = {}) {
+    const token = tokenInput.value.trim();
+    if (token) {
+        options.headers = { ...(options.headers || {}), 'Authorization': `Bearer ${token}` };
+    }
+    return fetch(url, options);
+}
+
 document.getElementById('runOnce').addEventListener('change', () => {
     document.getElementById('intervalSeconds').disabled = true;
 });
@@ -9,21 +26,23 @@ document.getElementById('startForm').addEventListener('submit', async (e) => {
     e.preventDefault();
 
     const excelFileName = document.getElementById('excelFileName').value;
-    const postgresUrl = document.getElementById('postgresUrl').value;
+    const fileId = document.getElementById('fileId').value;
+    const connection = document.getElementById('connection').value;
     const ignorantSheets = document.getElementById('ignorantSheets').value;
     const runOnce = document.getElementById('runOnce').checked;
     const intervalSeconds = runOnce ? 0 : parseInt(document.getElementById('intervalSeconds').value);
 
     const requestData = {
         excel_file_name: excelFileName,
-        postgres_url: postgresUrl,
+        file_id: fileId,
+        connection: connection,
         ignorant_sheets: ignorantSheets.split(',').map(sheet => sheet.trim()),
         once: runOnce,
         interval_seconds: intervalSeconds
     };
 
     try {
-        const response = await fetch('http://localhost:8080/api/start', {
+        const response = await apiFetch('http://localhost:8080/api/start', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify(requestData),
@@ -34,10 +53,101 @@ document.getElementById('startForm').addEventListener('submit', async (e) => {
     }
 });
 
+document.getElementById('uploadForm').addEventListener('submit', async (e) => {
+    e.preventDefault();
+
+    const input = document.getElementById('uploadFile');
+    if (input.files.length === 0) return;
+
+    const formData = new FormData();
+    formData.append('file', input.files[0]);
+
+    try {
+        const response = await apiFetch('http://localhost:8080/api/files', {
+            method: 'POST',
+            body: formData,
+        });
+        if (!response.ok) {
+            alert(`Upload failed: ${await response.text()}`);
+            return;
+        }
+        const result = await response.json();
+        await updateFiles();
+        document.getElementById('fileId').value = result.file.id;
+    } catch (err) {
+        alert(`Error uploading file: ${err.message}`);
+    }
+});
+
+async function updateConnections() {
+    try {
+        const response = await apiFetch('http://localhost:8080/api/connections');
+        const connections = await response.json();
+        const select = document.getElementById('connection');
+        const selected = select.value;
+        select.innerHTML = '';
+
+        connections.forEach(conn => {
+            const option = document.createElement('option');
+            option.value = conn.name;
+            option.textContent = `${conn.name} (${conn.database}@${conn.host})`;
+            select.appendChild(option);
+        });
+        if (selected) select.value = selected;
+    } catch (err) {
+        console.error('Error updating connections:', err);
+    }
+}
+
+document.getElementById('testConnection').addEventListener('click', async () => {
+    const name = document.getElementById('connection').value;
+    const result = document.getElementById('connectionResult');
+    if (!name) return;
+
+    try {
+        const response = await apiFetch(`http://localhost:8080/api/connections/${encodeURIComponent(name)}/test`, {
+            method: 'POST',
+        });
+        const status = await response.json();
+        result.textContent = status.ok ? `OK (${status.latency_ms} ms)` : status.error;
+    } catch (err) {
+        result.textContent = `Error: ${err.message}`;
+    }
+});
+
+async function updateFiles() {
+    try {
+        const response = await apiFetch('http://localhost:8080/api/files');
+        const files = await response.json();
+        const select = document.getElementById('fileId');
+        const selected = select.value;
+        select.innerHTML = '<option value="">(use a file from /app/data)</option>';
+
+        files.forEach(file => {
+            const option = document.createElement('option');
+            option.value = file.id;
+            option.textContent = `${file.name} (${file.id})`;
+            select.appendChild(option);
+        });
+        select.value = selected;
+    } catch (err) {
+        console.error('Error updating files:', err);
+    }
+}
+
+function formatProgress(progress, showFile) {
+    if (!progress || !progress.rows_total) return '';
+    const percent = Math.floor(progress.rows_processed * 100 / progress.rows_total);
+    const eta = progress.rows_processed < progress.rows_total ? `, ETA ${Math.ceil(progress.eta_seconds)}s` : '';
+    const file = showFile ? `${progress.file}: ` : '';
+    return `<br><small>${file}${progress.sheet} (${progress.sheet_index}/${progress.sheet_count}): ` +
+        `${progress.rows_processed}/${progress.rows_total} rows (${percent}%), ` +
+        `${progress.rows_failed} failed, ${Math.round(progress.rows_per_second)} rows/s${eta}</small>`;
+}
 
 async function updateInstances() {
     try {
-        const response = await fetch('http://localhost:8080/api/instances');
+        const response = await apiFetch('http://localhost:8080/api/instances');
         const instances = await response.json();
         const tbody = document.getElementById('instancesBody');
         tbody.innerHTML = '';
@@ -46,7 +156,7 @@ async function updateInstances() {
             const row = document.createElement('tr');
             row.innerHTML = `
                 <td>${inst.id}</td>
-                <td>${inst.status}</td>
+                <td>${inst.status}${inst.files && inst.files.length > 1 ? inst.files.map(p => formatProgress(p, true)).join('') : formatProgress(inst.progress)}</td>
                 <td><pre>${inst.config}</pre></td>
                 <td>${new Date(inst.started_at).toLocaleString()}</td>
                 <td>
@@ -63,7 +173,7 @@ async function updateInstances() {
 
 async function stopInstance(id) {
     try {
-        const response = await fetch(`http://localhost:8080/api/stop/${id}`, {
+        const response = await apiFetch(`http://localhost:8080/api/stop/${id}`, {
             method: 'POST',
         });
         if (response.ok) updateInstances();
@@ -74,7 +184,7 @@ async function stopInstance(id) {
 
 async function restartInstance(id) {
     try {
-        const response = await fetch(`http://localhost:8080/api/restart/${id}`, {
+        const response = await apiFetch(`http://localhost:8080/api/restart/${id}`, {
             method: 'POST',
         });
         if (response.ok) updateInstances();
@@ -85,7 +195,7 @@ async function restartInstance(id) {
 
 async function deleteInstance(id) {
     try {
-        const response = await fetch(`http://localhost:8080/api/delete/${id}`, {
+        const response = await apiFetch(`http://localhost:8080/api/delete/${id}`, {
             method: 'POST',
         });
         if (response.ok) updateInstances();
@@ -95,4 +205,6 @@ async function deleteInstance(id) {
 }
 
 setInterval(updateInstances, 5000);
-updateInstances();
\ No newline at end of file
+updateInstances();
+updateFiles();
+updateConnections();
\ No newline at end of file